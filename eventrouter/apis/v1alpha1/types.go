@@ -4,23 +4,240 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// A RegistrationFilter selects the events delivered to a Registration.
+// All the specified criteria must match; an empty criterion matches everything.
+type RegistrationFilter struct {
+	// APIGroups of the involved object ("core" or "" for the core API group).
+	// +optional
+	APIGroups []string `json:"apiGroups,omitempty"`
+
+	// Kinds of the involved object.
+	// +optional
+	Kinds []string `json:"kinds,omitempty"`
+
+	// Namespaces of the involved object.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Types of the event (Normal, Warning).
+	// +optional
+	Types []string `json:"types,omitempty"`
+
+	// Reasons is a list of regular expressions matched against the event reason.
+	// +optional
+	Reasons []string `json:"reasons,omitempty"`
+
+	// Selector is a label selector matched against the resolved involved object.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// A RetryPolicy defines how failed deliveries are retried.
+// Deliveries are retried on transport errors, 5xx and 429 responses.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of delivery attempts, including the first one.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+
+	// Backoff is the delay before the first retry; it doubles at each attempt.
+	// +optional
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+
+	// MaxBackoff caps the delay between two attempts.
+	// +optional
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+
+	// JitterPercent adds to each delay a random jitter up to the given percentage.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	JitterPercent int32 `json:"jitterPercent,omitempty"`
+}
+
+// A PayloadFormat is the format of the notifications sent to a Registration.
+// +kubebuilder:validation:Enum=json;cloudevents-binary;cloudevents-structured
+type PayloadFormat string
+
+const (
+	// PayloadFormatJSON sends the raw event as JSON.
+	PayloadFormatJSON PayloadFormat = "json"
+	// PayloadFormatCloudEventsBinary sends a CloudEvents 1.0 event
+	// in HTTP binary content mode.
+	PayloadFormatCloudEventsBinary PayloadFormat = "cloudevents-binary"
+	// PayloadFormatCloudEventsStructured sends a CloudEvents 1.0 event
+	// in HTTP structured content mode.
+	PayloadFormatCloudEventsStructured PayloadFormat = "cloudevents-structured"
+)
+
+// A BatchEncoding is the encoding of a batch of notifications.
+// +kubebuilder:validation:Enum=json;ndjson
+type BatchEncoding string
+
+const (
+	// BatchEncodingJSON sends the batch as a JSON array.
+	BatchEncodingJSON BatchEncoding = "json"
+	// BatchEncodingNDJSON sends the batch as newline delimited JSON.
+	BatchEncodingNDJSON BatchEncoding = "ndjson"
+)
+
+// A BatchPolicy groups the notifications before delivering them.
+type BatchPolicy struct {
+	// MaxSize is the maximum number of events in a batch.
+	// +kubebuilder:validation:Minimum=1
+	MaxSize int32 `json:"maxSize"`
+
+	// MaxLinger is the maximum time an event waits for its batch
+	// to fill up (default: 1s).
+	// +optional
+	MaxLinger *metav1.Duration `json:"maxLinger,omitempty"`
+
+	// Encoding of the batch (default: json).
+	// +optional
+	Encoding BatchEncoding `json:"encoding,omitempty"`
+}
+
+// A CircuitBreakerPolicy stops the deliveries to an endpoint that keeps
+// failing. Once open, the notifications are rejected (and stored as dead
+// letters) until the endpoint is probed healthy again.
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failed notifications
+	// opening the circuit (default: 5).
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+
+	// OpenDuration is how long the circuit stays open before
+	// the endpoint is probed (default: 30s).
+	// +optional
+	OpenDuration *metav1.Duration `json:"openDuration,omitempty"`
+
+	// HealthEndpoint is probed with a GET request before closing the
+	// circuit; when omitted the next notification is used as probe.
+	// +optional
+	HealthEndpoint string `json:"healthEndpoint,omitempty"`
+}
+
+// A DeliveryPolicy isolates the deliveries to a Registration
+// from the ones to the other Registrations.
+type DeliveryPolicy struct {
+	// Timeout of each delivery attempt (default: 40s).
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// MaxConcurrency is the maximum number of concurrent
+	// deliveries to this endpoint (default: 4).
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxConcurrency int32 `json:"maxConcurrency,omitempty"`
+
+	// CircuitBreaker tunes the circuit breaker of this endpoint;
+	// when omitted the default policy is used.
+	// +optional
+	CircuitBreaker *CircuitBreakerPolicy `json:"circuitBreaker,omitempty"`
+}
+
+// A SecretReference references a Secret in one of the namespaces
+// allowed by the eventrouter, by default its own.
+type SecretReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// A RegistrationAuth references the Secret holding the delivery credentials.
+// The Secret may contain any of the following keys:
+//   - token: sent as bearer token in the Authorization header
+//   - hmacKey: used to sign the payload (X-Krateo-Signature header)
+//   - tls.crt, tls.key: client certificate and key for mTLS
+//   - ca.crt: CA bundle used to verify the endpoint certificate
+type RegistrationAuth struct {
+	SecretRef SecretReference `json:"secretRef"`
+}
+
 // A RegistrationSpec defines the desired state of a Registration.
 type RegistrationSpec struct {
 	ServiceName string `json:"serviceName"`
 	Endpoint    string `json:"endpoint"`
+
+	// Filter selects the events delivered to this endpoint.
+	// When omitted every event is delivered.
+	// +optional
+	Filter *RegistrationFilter `json:"filter,omitempty"`
+
+	// Retry defines how failed deliveries are retried.
+	// When omitted the default retry policy is used.
+	// +optional
+	Retry *RetryPolicy `json:"retry,omitempty"`
+
+	// Format of the notifications payload (default: json).
+	// +optional
+	Format PayloadFormat `json:"format,omitempty"`
+
+	// Auth references the credentials used to deliver the notifications.
+	// +optional
+	Auth *RegistrationAuth `json:"auth,omitempty"`
+
+	// Batch enables the batched delivery of the notifications.
+	// +optional
+	Batch *BatchPolicy `json:"batch,omitempty"`
+
+	// Delivery tunes the concurrency, timeout and circuit
+	// breaker of the deliveries to this endpoint.
+	// +optional
+	Delivery *DeliveryPolicy `json:"delivery,omitempty"`
+}
+
+const (
+	// ConditionTypeReady indicates whether the registered endpoint
+	// is accepting notifications.
+	ConditionTypeReady = "Ready"
+)
+
+// A RegistrationStatus reports the delivery health of a Registration.
+type RegistrationStatus struct {
+	// LastDeliveryTime is the time of the last successful delivery.
+	// +optional
+	LastDeliveryTime *metav1.Time `json:"lastDeliveryTime,omitempty"`
+
+	// LastError is the error of the last failed delivery.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// ConsecutiveFailures is the number of failed deliveries
+	// since the last successful one.
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// Delivered is the total number of delivered notifications.
+	// +optional
+	Delivered int64 `json:"delivered,omitempty"`
+
+	// Failed is the total number of notifications that exhausted their retries.
+	// +optional
+	Failed int64 `json:"failed,omitempty"`
+
+	// Conditions of the Registration.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 
 // A Registration registers a new eventrouter registration.
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status"
+// +kubebuilder:printcolumn:name="FAILURES",type="integer",JSONPath=".status.consecutiveFailures"
+// +kubebuilder:printcolumn:name="LAST DELIVERY",type="date",JSONPath=".status.lastDeliveryTime"
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
 // +kubebuilder:resource:scope=Cluster
 type Registration struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec RegistrationSpec `json:"spec"`
+	Spec   RegistrationSpec   `json:"spec"`
+	Status RegistrationStatus `json:"status,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,169 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	entryExt = ".json"
+	// invalidExt is appended to the entries that can't be decoded:
+	// they are left aside for inspection and no longer replayed
+	invalidExt = ".invalid"
+	// archivedExt is appended to the archived entries
+	archivedExt = ".archived"
+)
+
+// ErrArchive is returned by the Replay function to archive the entry:
+// it's left aside for inspection and no longer replayed.
+var ErrArchive = errors.New("dead letter archived")
+
+// Entry is a notification that exhausted all its delivery attempts;
+// Attempts also counts the ones made by the previous replays.
+type Entry struct {
+	Registration string       `json:"registration"`
+	Endpoint     string       `json:"endpoint"`
+	Attempts     int          `json:"attempts"`
+	LastError    string       `json:"lastError,omitempty"`
+	Time         time.Time    `json:"time"`
+	Event        corev1.Event `json:"event"`
+}
+
+// Sink receives the undelivered notifications.
+type Sink interface {
+	Put(e Entry) error
+}
+
+// Store is a Sink whose entries can be replayed.
+type Store interface {
+	Sink
+	// Replay calls fn for each stored entry, oldest first.
+	// Entries for which fn returns nil are removed from the store,
+	// the ones for which it returns ErrArchive are archived; the
+	// ones that can't be read are skipped.
+	Replay(fn func(Entry) error) (int, error)
+}
+
+var _ Store = (*FileStore)(nil)
+
+// FileStore is a Store that keeps each entry in a JSON file
+// inside a local directory.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore creates a FileStore rooted at dir, creating
+// the directory if necessary.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Put stores the entry in a new file.
+func (s *FileStore) Put(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	dat, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%s-%s%s", e.Time.UnixNano(),
+		e.Registration, e.Event.UID, entryExt)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(s.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(dat); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+// Replay calls fn for each stored entry, oldest first.
+// It returns the number of replayed (and removed) entries; the
+// entries that can't be read or decoded don't stop the replay,
+// their errors are returned once all the others are done.
+func (s *FileStore) Replay(fn func(Entry) error) (int, error) {
+	s.mu.Lock()
+	all, err := os.ReadDir(s.dir)
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	names := make([]string, 0, len(all))
+	for _, el := range all {
+		if el.IsDir() || !strings.HasSuffix(el.Name(), entryExt) {
+			continue
+		}
+		names = append(names, el.Name())
+	}
+	sort.Strings(names)
+
+	var errs []error
+
+	tot := 0
+	for _, name := range names {
+		fp := filepath.Join(s.dir, name)
+
+		dat, err := os.ReadFile(fp)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(dat, &e); err != nil {
+			errs = append(errs, fmt.Errorf("unable to decode dead letter %q (moved to %q): %w",
+				name, name+invalidExt, err))
+			if err := os.Rename(fp, fp+invalidExt); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if err := fn(e); err != nil {
+			if errors.Is(err, ErrArchive) {
+				if err := os.Rename(fp, fp+archivedExt); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			continue
+		}
+
+		if err := os.Remove(fp); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		tot++
+	}
+
+	return tot, errors.Join(errs...)
+}
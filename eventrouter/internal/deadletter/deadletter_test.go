@@ -0,0 +1,168 @@
+package deadletter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestFileStoreReplay(t *testing.T) {
+	sto, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i, uid := range []string{"aaa", "bbb", "ccc"} {
+		err := sto.Put(Entry{
+			Registration: "test",
+			Endpoint:     "http://127.0.0.1:9090/handle",
+			Attempts:     3,
+			Time:         now.Add(time.Duration(i) * time.Second),
+			Event: corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{UID: types.UID("uid-" + uid)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := []string{}
+	tot, err := sto.Replay(func(e Entry) error {
+		got = append(got, string(e.Event.UID))
+		if e.Event.UID == "uid-bbb" {
+			return errors.New("still failing")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tot != 2 {
+		t.Fatalf("replayed: got %d, expected 2", tot)
+	}
+
+	exp := []string{"uid-aaa", "uid-bbb", "uid-ccc"}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("replay order: got %v, expected %v", got, exp)
+		}
+	}
+
+	left := 0
+	if _, err := sto.Replay(func(e Entry) error {
+		left++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if left != 1 {
+		t.Fatalf("remaining entries: got %d, expected 1", left)
+	}
+}
+
+func TestFileStoreReplayInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	sto, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i, uid := range []string{"aaa", "ccc"} {
+		err := sto.Put(Entry{
+			Registration: "test",
+			Time:         now.Add(time.Duration(i*2) * time.Second),
+			Event: corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{UID: types.UID("uid-" + uid)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bad := filepath.Join(dir, fmt.Sprintf("%020d-test-uid-bbb%s", now.Add(time.Second).UnixNano(), entryExt))
+	if err := os.WriteFile(bad, []byte("{lorem ipsum"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	tot, err := sto.Replay(func(e Entry) error {
+		got = append(got, string(e.Event.UID))
+		return nil
+	})
+	if err == nil {
+		t.Error("expected the decoding error")
+	}
+	if tot != 2 || len(got) != 2 || got[1] != "uid-ccc" {
+		t.Fatalf("replayed: got %d %v, expected the valid entries", tot, got)
+	}
+
+	if _, err := os.Stat(bad + invalidExt); err != nil {
+		t.Errorf("invalid entry not set aside: %v", err)
+	}
+
+	// the invalid entry is no longer replayed
+	if _, err := sto.Replay(func(e Entry) error { return nil }); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileStoreReplayArchive(t *testing.T) {
+	dir := t.TempDir()
+	sto, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uid := range []string{"aaa", "bbb"} {
+		err := sto.Put(Entry{
+			Registration: "test",
+			Event: corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{UID: types.UID("uid-" + uid)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tot, err := sto.Replay(func(e Entry) error {
+		if e.Event.UID == "uid-aaa" {
+			return fmt.Errorf("%w: registration not found", ErrArchive)
+		}
+		return errors.New("still failing")
+	})
+	if err != nil || tot != 0 {
+		t.Fatalf("got %d (%v), expected no replayed entries", tot, err)
+	}
+
+	archived, err := filepath.Glob(filepath.Join(dir, "*"+entryExt+archivedExt))
+	if err != nil || len(archived) != 1 {
+		t.Fatalf("archived: got %v (%v), expected one entry", archived, err)
+	}
+
+	// the archived entry is no longer replayed
+	left := 0
+	if _, err := sto.Replay(func(e Entry) error {
+		left++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if left != 1 {
+		t.Errorf("replayed: got %d, expected 1", left)
+	}
+}
@@ -0,0 +1,108 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Check returns an error when the checked component is not healthy.
+type Check func() error
+
+// Handler runs all the checks: it responds 200 when all of
+// them pass and 503 listing the failed ones otherwise.
+func Handler(checks map[string]Check) http.Handler {
+	names := make([]string, 0, len(checks))
+	for k := range checks {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return &handler{
+		names:  names,
+		checks: checks,
+	}
+}
+
+var _ http.Handler = (*handler)(nil)
+
+type handler struct {
+	names  []string
+	checks map[string]Check
+}
+
+func (h *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
+	var failed []string
+	for _, name := range h.names {
+		if err := h.checks[name](); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	}
+
+	wri.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	wri.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if len(failed) > 0 {
+		wri.WriteHeader(http.StatusServiceUnavailable)
+		wri.Write([]byte(strings.Join(failed, "\n")))
+		return
+	}
+
+	wri.WriteHeader(http.StatusOK)
+	wri.Write([]byte("ok"))
+}
+
+// Synced fails until the hasSynced function returns true.
+func Synced(hasSynced func() bool) Check {
+	return func() error {
+		if !hasSynced() {
+			return fmt.Errorf("not synced")
+		}
+		return nil
+	}
+}
+
+// Dispatcher is implemented by the queues reporting their progress.
+type Dispatcher interface {
+	GetJobCount() int
+	GetLastDispatch() time.Time
+}
+
+// Progress fails when jobs are waiting in the queue but none
+// has been handed to a worker for longer than the timeout.
+func Progress(q Dispatcher, timeout time.Duration) Check {
+	return func() error {
+		if q.GetJobCount() == 0 {
+			return nil
+		}
+
+		if since := time.Since(q.GetLastDispatch()); since > timeout {
+			return fmt.Errorf("no job dispatched for %s (%d waiting)",
+				since.Truncate(time.Second), q.GetJobCount())
+		}
+		return nil
+	}
+}
+
+// ProgressAll fails when any of the dispatchers returned by all
+// makes no progress (see Progress), e.g. a queue per endpoint.
+func ProgressAll(all func() map[string]Dispatcher, timeout time.Duration) Check {
+	return func() error {
+		items := all()
+
+		names := make([]string, 0, len(items))
+		for k := range items {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := Progress(items[name], timeout)(); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return nil
+	}
+}
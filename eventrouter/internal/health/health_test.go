@@ -0,0 +1,90 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeDispatcher struct {
+	jobs         int
+	lastDispatch time.Time
+}
+
+func (f *fakeDispatcher) GetJobCount() int           { return f.jobs }
+func (f *fakeDispatcher) GetLastDispatch() time.Time { return f.lastDispatch }
+
+func TestHandler(t *testing.T) {
+	synced := false
+
+	h := Handler(map[string]Check{
+		"informer": Synced(func() bool { return synced }),
+		"ping":     func() error { return nil },
+	})
+
+	tests := []struct {
+		synced bool
+		code   int
+		body   string
+	}{
+		{false, http.StatusServiceUnavailable, "informer: not synced"},
+		{true, http.StatusOK, "ok"},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("synced=%t", tc.synced), func(t *testing.T) {
+			synced = tc.synced
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rr.Code != tc.code {
+				t.Errorf("status: got %d, expected %d", rr.Code, tc.code)
+			}
+			if rr.Body.String() != tc.body {
+				t.Errorf("body: got %q, expected %q", rr.Body.String(), tc.body)
+			}
+		})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       fakeDispatcher
+		wantErr bool
+	}{
+		{"idle", fakeDispatcher{jobs: 0, lastDispatch: time.Now().Add(-time.Hour)}, false},
+		{"dispatching", fakeDispatcher{jobs: 5, lastDispatch: time.Now()}, false},
+		{"stuck", fakeDispatcher{jobs: 5, lastDispatch: time.Now().Add(-time.Hour)}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Progress(&tc.q, time.Minute)()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, expected error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestProgressAll(t *testing.T) {
+	all := map[string]Dispatcher{
+		"idle":        &fakeDispatcher{jobs: 0, lastDispatch: time.Now().Add(-time.Hour)},
+		"dispatching": &fakeDispatcher{jobs: 5, lastDispatch: time.Now()},
+	}
+	check := ProgressAll(func() map[string]Dispatcher { return all }, time.Minute)
+
+	if err := check(); err != nil {
+		t.Errorf("got error %v, expected none", err)
+	}
+
+	all["stuck"] = &fakeDispatcher{jobs: 5, lastDispatch: time.Now().Add(-time.Hour)}
+	if err := check(); err == nil || !strings.HasPrefix(err.Error(), "stuck: ") {
+		t.Errorf("got error %v, expected the stuck dispatcher", err)
+	}
+}
@@ -10,12 +10,20 @@ type ClientOpts struct {
 	Verbose  bool
 	Insecure bool
 	Timeout  time.Duration
+	// TLSConfig holds client certificates and CA bundles (optional).
+	TLSConfig *tls.Config
 }
 
 func ClientFromOpts(opts ClientOpts) *http.Client {
 	transport := defaultTransport()
 
-	if opts.Insecure {
+	if opts.TLSConfig != nil {
+		tlsConfig := opts.TLSConfig.Clone()
+		tlsConfig.InsecureSkipVerify = opts.Insecure
+
+		t := transport.(*http.Transport)
+		t.TLSClientConfig = tlsConfig
+	} else if opts.Insecure {
 		tlsConfig := &tls.Config{
 			InsecureSkipVerify: true,
 		}
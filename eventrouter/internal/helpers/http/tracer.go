@@ -45,3 +45,14 @@ func (t *Tracer) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	return resp, err
 }
+
+// CloseIdleConnections closes the idle connections of the nested
+// RoundTripper, if it supports it.
+func (t *Tracer) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if ci, ok := t.RoundTripper.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
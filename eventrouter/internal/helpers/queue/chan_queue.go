@@ -3,11 +3,19 @@ package queue
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // NewQueue create a queue that specifies the number of buffers and the number of worker threads
 func NewQueue(maxCapacity, maxThread int) *Queue {
+	return NewQueueWithOverflow(maxCapacity, maxThread, Overflow{Policy: OverflowBlock})
+}
+
+// NewQueueWithOverflow create a queue that specifies the number of buffers, the number
+// of worker threads and the behavior once the buffers are full
+func NewQueueWithOverflow(maxCapacity, maxThread int, overflow Overflow) *Queue {
 	return &Queue{
+		overflow:   overflow,
 		jobQueue:   make(chan Jober, maxCapacity),
 		maxWorkers: maxThread,
 		workerPool: make(chan chan Jober, maxThread),
@@ -19,12 +27,16 @@ func NewQueue(maxCapacity, maxThread int) *Queue {
 // Queue a task queue for mitigating server pressure in high concurrency situations
 // and improving task processing
 type Queue struct {
+	overflow   Overflow
 	maxWorkers int
 	jobQueue   chan Jober
 	workerPool chan chan Jober
 	workers    []*worker
 	running    uint32
-	wg         *sync.WaitGroup
+	busy       int32
+	// lastDispatch is the time (unix nano) a job was last handed to a worker
+	lastDispatch int64
+	wg           *sync.WaitGroup
 }
 
 // Run start running queues
@@ -34,8 +46,13 @@ func (q *Queue) Run() {
 	}
 
 	atomic.StoreUint32(&q.running, 1)
+	atomic.StoreInt64(&q.lastDispatch, time.Now().UnixNano())
+
+	if q.overflow.Spill != nil {
+		q.overflow.Spill.Run()
+	}
 	for i := 0; i < q.maxWorkers; i++ {
-		q.workers[i] = newWorker(q.workerPool, q.wg)
+		q.workers[i] = newWorker(q.workerPool, q.wg, &q.busy)
 		q.workers[i].Start()
 	}
 
@@ -46,6 +63,7 @@ func (q *Queue) dispatcher() {
 	for job := range q.jobQueue {
 		worker := <-q.workerPool
 		worker <- job
+		atomic.StoreInt64(&q.lastDispatch, time.Now().UnixNano())
 	}
 }
 
@@ -63,18 +81,90 @@ func (q *Queue) Terminate() {
 		q.workers[i].Stop()
 	}
 	close(q.workerPool)
+
+	if q.overflow.Spill != nil {
+		q.overflow.Spill.Terminate()
+	}
 }
 
-// Push put the executable task into the queue
+// Push put the executable task into the queue; once the buffers
+// are full the job is handled according to the overflow policy
 func (q *Queue) Push(job Jober) {
 	if atomic.LoadUint32(&q.running) != 1 {
 		return
 	}
 
 	q.wg.Add(1)
-	q.jobQueue <- job
+
+	select {
+	case q.jobQueue <- job:
+		return
+	default:
+	}
+
+	switch q.overflow.Policy {
+	case OverflowDropNewest:
+		q.wg.Done()
+		q.overflow.drop(job)
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case old := <-q.jobQueue:
+				q.wg.Done()
+				q.overflow.drop(old)
+			default:
+			}
+
+			select {
+			case q.jobQueue <- job:
+				return
+			default:
+			}
+		}
+
+	case OverflowSpill:
+		q.wg.Done()
+		q.overflow.Spill.Push(job)
+
+	default:
+		if q.overflow.Timeout <= 0 {
+			q.jobQueue <- job
+			return
+		}
+
+		timer := time.NewTimer(q.overflow.Timeout)
+		defer timer.Stop()
+
+		select {
+		case q.jobQueue <- job:
+		case <-timer.C:
+			q.wg.Done()
+			q.overflow.drop(job)
+		}
+	}
+}
+
+// Restore restores the jobs persisted by the spill queue, if any
+func (q *Queue) Restore(decode func([]byte) (Jober, error)) (int, error) {
+	return q.overflow.restore(decode)
 }
 
 func (q *Queue) GetJobCount() int {
 	return len(q.jobQueue)
 }
+
+// GetBusyWorkers returns the number of workers running a job
+func (q *Queue) GetBusyWorkers() int {
+	return int(atomic.LoadInt32(&q.busy))
+}
+
+// GetMaxWorkers returns the number of worker threads
+func (q *Queue) GetMaxWorkers() int {
+	return q.maxWorkers
+}
+
+// GetLastDispatch returns the last time a job was handed to a worker
+func (q *Queue) GetLastDispatch() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&q.lastDispatch))
+}
@@ -0,0 +1,684 @@
+package queue
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	segmentExt = ".seg"
+	ackExt     = ".ack"
+
+	recordHeaderSize = 8
+
+	defaultSegmentBytes = 4 << 20
+	defaultTimeout      = 10 * time.Second
+)
+
+// Marshaler is a Jober that can be persisted by the DiskQueue.
+type Marshaler interface {
+	Jober
+	MarshalJob() ([]byte, error)
+}
+
+// Restorer is a Queuer whose persisted jobs can be restored
+// after a restart.
+type Restorer interface {
+	// Restore decodes the jobs left pending by a previous run and
+	// queues them ahead of the new ones; it returns how many
+	// jobs have been restored.
+	Restore(decode func([]byte) (Jober, error)) (int, error)
+}
+
+type DiskQueueOpts struct {
+	// Dir is the directory holding the segment files.
+	Dir string
+	// MaxBytes is the maximum size of the pending jobs; Push
+	// blocks until some space is freed (zero means unlimited).
+	MaxBytes int64
+	// Timeout is how long Push waits for some space to be freed
+	// before dropping the job (default: 10s).
+	Timeout time.Duration
+	// OnDrop is called with the jobs dropped because MaxBytes has
+	// been reached (optional).
+	OnDrop func(job Jober)
+	// SegmentBytes is the size after which a new segment
+	// file is started (default: 4MiB).
+	SegmentBytes int64
+	// MaxWorkers is the number of worker threads.
+	MaxWorkers int
+	// ErrorHandler is called with the I/O errors (optional); the
+	// jobs that cannot be persisted are kept in memory only.
+	ErrorHandler func(err error)
+}
+
+// NewDiskQueue create a queue that persists the pending jobs in a
+// write-ahead log made of segment files; the jobs still pending when
+// the process stops are restored at the next startup (see Restore).
+//
+// Each segment file is paired with an acknowledgement file listing the
+// jobs completed; a segment is removed once all its jobs are completed.
+// Jobs that don't implement Marshaler are kept in memory only.
+func NewDiskQueue(opts DiskQueueOpts) (*DiskQueue, error) {
+	if err := os.MkdirAll(opts.Dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	stale, err := listSegments(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentBytes := opts.SegmentBytes
+	if segmentBytes <= 0 {
+		segmentBytes = defaultSegmentBytes
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	q := &DiskQueue{
+		dir:          opts.Dir,
+		maxBytes:     opts.MaxBytes,
+		timeout:      timeout,
+		onDrop:       opts.OnDrop,
+		segmentBytes: segmentBytes,
+		maxWorkers:   opts.MaxWorkers,
+		onError:      opts.ErrorHandler,
+		workerPool:   make(chan chan Jober, opts.MaxWorkers),
+		workers:      make([]*worker, opts.MaxWorkers),
+		wg:           new(sync.WaitGroup),
+		pending:      list.New(),
+		segments:     map[uint64]*segment{},
+		stale:        stale,
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	if len(stale) > 0 {
+		q.nextSegment = stale[len(stale)-1] + 1
+	}
+
+	return q, nil
+}
+
+var (
+	_ Queuer   = (*DiskQueue)(nil)
+	_ Restorer = (*DiskQueue)(nil)
+)
+
+// DiskQueue a task queue whose pending jobs survive restarts
+type DiskQueue struct {
+	dir          string
+	maxBytes     int64
+	timeout      time.Duration
+	onDrop       func(job Jober)
+	segmentBytes int64
+	maxWorkers   int
+	onError      func(err error)
+	workerPool   chan chan Jober
+	workers      []*worker
+	wg           *sync.WaitGroup
+	quit         chan struct{}
+	done         chan struct{}
+	busy         int32
+	// lastDispatch is the time (unix nano) a job was last handed to a worker
+	lastDispatch int64
+
+	// wmu serializes the writes to the active segment, so that
+	// they don't hold up the workers acknowledging the jobs
+	wmu sync.Mutex
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	running     bool
+	pending     *list.List
+	bytes       int64
+	segments    map[uint64]*segment
+	active      *segment
+	nextSegment uint64
+	stale       []uint64
+}
+
+// segment is a file of the write-ahead log.
+type segment struct {
+	id      uint64
+	file    *os.File
+	ack     *os.File
+	size    int64
+	records uint32
+	pending int
+}
+
+// diskItem is a queued job and its position in the write-ahead log
+// (seg is nil for the jobs kept in memory only).
+type diskItem struct {
+	job  Jober
+	seg  *segment
+	idx  uint32
+	size int64
+}
+
+// Run start running queues
+func (q *DiskQueue) Run() {
+	q.mu.Lock()
+	if q.running {
+		q.mu.Unlock()
+		return
+	}
+	q.running = true
+	q.quit = make(chan struct{})
+	q.done = make(chan struct{})
+	q.mu.Unlock()
+
+	atomic.StoreInt64(&q.lastDispatch, time.Now().UnixNano())
+
+	for i := 0; i < q.maxWorkers; i++ {
+		q.workers[i] = newWorker(q.workerPool, q.wg, &q.busy)
+		q.workers[i].Start()
+	}
+
+	go q.dispatcher()
+}
+
+func (q *DiskQueue) dispatcher() {
+	defer close(q.done)
+
+	for {
+		q.mu.Lock()
+		for q.running && q.pending.Len() == 0 {
+			q.cond.Wait()
+		}
+		if !q.running {
+			q.mu.Unlock()
+			return
+		}
+		ele := q.pending.Front()
+		q.pending.Remove(ele)
+		q.mu.Unlock()
+
+		item := ele.Value.(*diskItem)
+
+		select {
+		case worker := <-q.workerPool:
+			q.wg.Add(1)
+			worker <- NewJob(item, q.complete)
+			atomic.StoreInt64(&q.lastDispatch, time.Now().UnixNano())
+		case <-q.quit:
+			// still pending: it's restored at the next startup
+			q.mu.Lock()
+			q.pending.PushFront(item)
+			q.mu.Unlock()
+			return
+		}
+	}
+}
+
+// complete runs the job and acknowledges it, once completed.
+func (q *DiskQueue) complete(v interface{}) {
+	item := v.(*diskItem)
+	if aj, ok := item.job.(AsyncJober); ok {
+		aj.JobAsync(func() { q.ack(item) })
+		return
+	}
+	item.job.Job()
+	q.ack(item)
+}
+
+// Terminate stops dispatching the jobs and waits for the running ones;
+// the pending jobs are left in the write-ahead log.
+func (q *DiskQueue) Terminate() {
+	q.mu.Lock()
+	if !q.running {
+		q.mu.Unlock()
+		return
+	}
+	q.running = false
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	close(q.quit)
+	<-q.done
+	q.wg.Wait()
+
+	for i := 0; i < q.maxWorkers; i++ {
+		q.workers[i].Stop()
+	}
+}
+
+// Push put the executable task into the queue; the job is
+// persisted even after the queue has been terminated, so that
+// it's run after the next startup.
+//
+// Once MaxBytes is reached, Push waits up to Timeout for some
+// space to be freed, then drops the job.
+func (q *DiskQueue) Push(job Jober) {
+	var dat []byte
+	if m, ok := job.(Marshaler); ok {
+		var err error
+		dat, err = m.MarshalJob()
+		if err != nil {
+			q.error(fmt.Errorf("cannot marshal job: %w", err))
+			dat = nil
+		}
+	}
+
+	item := &diskItem{job: job}
+	if dat != nil {
+		item.size = int64(len(dat) + recordHeaderSize)
+	}
+
+	if !q.reserve(item.size) {
+		if q.onDrop != nil {
+			q.onDrop(job)
+		}
+		return
+	}
+
+	if dat != nil {
+		if err := q.append(item, dat); err != nil {
+			q.error(err)
+			q.release(item.size)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.running && item.seg == nil {
+		// neither persisted nor dispatched
+		return
+	}
+
+	q.pending.PushBack(item)
+	q.cond.Broadcast()
+}
+
+// reserve waits up to the timeout for the job to fit in MaxBytes
+// and accounts its size; it returns false if the job doesn't fit.
+func (q *DiskQueue) reserve(size int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	full := func() bool {
+		return q.running && q.maxBytes > 0 && q.bytes > 0 &&
+			q.bytes+max(size, recordHeaderSize) > q.maxBytes
+	}
+
+	if full() {
+		// sync.Cond doesn't support timeouts: wake up the waiters
+		deadline := time.Now().Add(q.timeout)
+		t := time.AfterFunc(q.timeout, func() {
+			q.mu.Lock()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		})
+		defer t.Stop()
+
+		for full() {
+			if !time.Now().Before(deadline) {
+				return false
+			}
+			q.cond.Wait()
+		}
+	}
+
+	q.bytes += size
+	return true
+}
+
+// release gives back the space reserved by a job never persisted.
+func (q *DiskQueue) release(size int64) {
+	q.mu.Lock()
+	q.bytes -= size
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// append writes the job to the active segment and flushes it to
+// disk; the space has been reserved by the caller.
+func (q *DiskQueue) append(item *diskItem, dat []byte) error {
+	q.wmu.Lock()
+	defer q.wmu.Unlock()
+
+	q.mu.Lock()
+	seg := q.active
+	q.mu.Unlock()
+
+	if seg == nil || seg.size >= q.segmentBytes {
+		var err error
+		if seg, err = q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, recordHeaderSize+len(dat))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(dat)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(dat))
+	copy(buf[recordHeaderSize:], dat)
+
+	_, err := seg.file.Write(buf)
+	if err == nil {
+		err = seg.file.Sync()
+	}
+	if err != nil {
+		// discard the partial record, if any
+		seg.file.Truncate(seg.size)
+		seg.file.Seek(seg.size, io.SeekStart)
+		return fmt.Errorf("cannot write segment %d: %w", seg.id, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item.seg, item.idx = seg, seg.records
+
+	seg.size += item.size
+	seg.records++
+	seg.pending++
+
+	return nil
+}
+
+// rotate closes the active segment and starts a new one; it must
+// be called with the write lock held.
+func (q *DiskQueue) rotate() (*segment, error) {
+	q.mu.Lock()
+	if prev := q.active; prev != nil {
+		prev.file.Close()
+		prev.file = nil
+		q.active = nil
+		if prev.pending == 0 {
+			q.remove(prev)
+		}
+	}
+	id := q.nextSegment
+	q.nextSegment++
+	q.mu.Unlock()
+
+	f, err := os.OpenFile(q.segmentPath(id, segmentExt), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create segment %d: %w", id, err)
+	}
+	// persist the new directory entry too
+	if err := syncDir(q.dir); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("cannot create segment %d: %w", id, err)
+	}
+
+	seg := &segment{id: id, file: f}
+
+	q.mu.Lock()
+	q.active = seg
+	q.segments[id] = seg
+	q.mu.Unlock()
+
+	return seg, nil
+}
+
+// ack records the completion of the job and removes its segment
+// once all the segment jobs are completed.
+func (q *DiskQueue) ack(item *diskItem) {
+	if item.seg == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seg := item.seg
+	seg.pending--
+	q.bytes -= item.size
+	q.cond.Broadcast()
+
+	if seg.pending == 0 && seg != q.active {
+		q.remove(seg)
+		return
+	}
+
+	if err := q.writeAck(seg, item.idx); err != nil {
+		q.error(err)
+	}
+}
+
+func (q *DiskQueue) writeAck(seg *segment, idx uint32) error {
+	if seg.ack == nil {
+		f, err := os.OpenFile(q.segmentPath(seg.id, ackExt), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return fmt.Errorf("cannot open acks of segment %d: %w", seg.id, err)
+		}
+		seg.ack = f
+	}
+
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], idx)
+	if _, err := seg.ack.Write(buf[:]); err != nil {
+		return fmt.Errorf("cannot write acks of segment %d: %w", seg.id, err)
+	}
+
+	return nil
+}
+
+// remove deletes the segment files; it must be called with the lock held.
+func (q *DiskQueue) remove(seg *segment) {
+	if seg.file != nil {
+		seg.file.Close()
+	}
+	if seg.ack != nil {
+		seg.ack.Close()
+	}
+	delete(q.segments, seg.id)
+
+	for _, ext := range []string{ackExt, segmentExt} {
+		err := os.Remove(q.segmentPath(seg.id, ext))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			q.error(err)
+		}
+	}
+}
+
+// Restore decodes the jobs left pending by a previous run; the
+// records that cannot be decoded are reported and discarded.
+func (q *DiskQueue) Restore(decode func([]byte) (Jober, error)) (int, error) {
+	q.mu.Lock()
+	stale := q.stale
+	q.stale = nil
+	q.mu.Unlock()
+
+	var restored []*diskItem
+	for _, id := range stale {
+		items, err := q.readSegment(id, decode)
+		if err != nil {
+			return 0, err
+		}
+		restored = append(restored, items...)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := len(restored) - 1; i >= 0; i-- {
+		q.pending.PushFront(restored[i])
+	}
+	q.cond.Broadcast()
+
+	return len(restored), nil
+}
+
+// readSegment returns the pending jobs of a segment left by a previous run.
+func (q *DiskQueue) readSegment(id uint64, decode func([]byte) (Jober, error)) ([]*diskItem, error) {
+	acked, err := q.readAcks(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(q.segmentPath(id, segmentExt))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open segment %d: %w", id, err)
+	}
+	defer f.Close()
+
+	seg := &segment{id: id}
+
+	var res []*diskItem
+	var dropped []uint32
+	for idx := uint32(0); ; idx++ {
+		var hdr [recordHeaderSize]byte
+		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+			if !errors.Is(err, io.EOF) {
+				q.error(fmt.Errorf("truncated record %d in segment %d", idx, id))
+			}
+			break
+		}
+
+		dat := make([]byte, binary.LittleEndian.Uint32(hdr[0:4]))
+		if _, err := io.ReadFull(f, dat); err != nil {
+			q.error(fmt.Errorf("truncated record %d in segment %d", idx, id))
+			break
+		}
+		if crc32.ChecksumIEEE(dat) != binary.LittleEndian.Uint32(hdr[4:8]) {
+			q.error(fmt.Errorf("corrupted record %d in segment %d", idx, id))
+			break
+		}
+
+		seg.records++
+		if _, ok := acked[idx]; ok {
+			continue
+		}
+
+		job, err := decode(dat)
+		if err != nil {
+			q.error(fmt.Errorf("cannot decode record %d in segment %d: %w", idx, id, err))
+			dropped = append(dropped, idx)
+			continue
+		}
+
+		size := int64(len(dat) + recordHeaderSize)
+		res = append(res, &diskItem{job: job, seg: seg, idx: idx, size: size})
+		seg.size += size
+		seg.pending++
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if seg.pending == 0 {
+		q.remove(seg)
+		return nil, nil
+	}
+
+	q.segments[id] = seg
+	q.bytes += seg.size
+
+	for _, idx := range dropped {
+		if err := q.writeAck(seg, idx); err != nil {
+			q.error(err)
+		}
+	}
+
+	return res, nil
+}
+
+func (q *DiskQueue) readAcks(id uint64) (map[uint32]struct{}, error) {
+	dat, err := os.ReadFile(q.segmentPath(id, ackExt))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read acks of segment %d: %w", id, err)
+	}
+
+	res := make(map[uint32]struct{}, len(dat)/4)
+	for i := 0; i+4 <= len(dat); i += 4 {
+		res[binary.LittleEndian.Uint32(dat[i:i+4])] = struct{}{}
+	}
+
+	return res, nil
+}
+
+// syncDir flushes the entries of the directory to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (q *DiskQueue) segmentPath(id uint64, ext string) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, ext))
+}
+
+func (q *DiskQueue) error(err error) {
+	if q.onError != nil {
+		q.onError(err)
+	}
+}
+
+// GetJobCount returns the number of jobs waiting for a worker
+func (q *DiskQueue) GetJobCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending.Len()
+}
+
+// GetBytes returns the size of the persisted jobs not yet completed
+func (q *DiskQueue) GetBytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+// GetBusyWorkers returns the number of workers running a job
+func (q *DiskQueue) GetBusyWorkers() int {
+	return int(atomic.LoadInt32(&q.busy))
+}
+
+// GetMaxWorkers returns the number of worker threads
+func (q *DiskQueue) GetMaxWorkers() int {
+	return q.maxWorkers
+}
+
+// GetLastDispatch returns the last time a job was handed to a worker
+func (q *DiskQueue) GetLastDispatch() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&q.lastDispatch))
+}
+
+// listSegments returns the ids of the segments in dir, sorted.
+func listSegments(dir string) ([]uint64, error) {
+	all, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []uint64
+	for _, el := range all {
+		name := el.Name()
+		if el.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, id)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+
+	return res, nil
+}
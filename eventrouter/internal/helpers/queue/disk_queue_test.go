@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordJob struct {
+	v    string
+	mu   *sync.Mutex
+	done *[]string
+}
+
+func (j *recordJob) Job() {
+	j.mu.Lock()
+	*j.done = append(*j.done, j.v)
+	j.mu.Unlock()
+}
+
+func (j *recordJob) MarshalJob() ([]byte, error) {
+	return []byte(j.v), nil
+}
+
+func TestDiskQueueRestore(t *testing.T) {
+	dir := t.TempDir()
+
+	var (
+		mu   sync.Mutex
+		done []string
+	)
+
+	newJob := func(v string) Jober {
+		return &recordJob{v: v, mu: &mu, done: &done}
+	}
+
+	q, err := NewDiskQueue(DiskQueueOpts{Dir: dir, MaxWorkers: 1, SegmentBytes: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Run()
+	for _, v := range []string{"a", "b", "c", "d", "e"} {
+		q.Push(newJob(v))
+	}
+	for q.GetBytes() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Terminate()
+
+	// pushed after the termination: persisted only
+	for _, v := range []string{"f", "g", "h"} {
+		q.Push(newJob(v))
+	}
+
+	if len(done) != 5 {
+		t.Fatalf("first run: got %v, expected 5 jobs", done)
+	}
+
+	q, err = NewDiskQueue(DiskQueueOpts{Dir: dir, MaxWorkers: 1, SegmentBytes: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := q.Restore(func(dat []byte) (Jober, error) {
+		return newJob(string(dat)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("restored: got %d, expected 3", n)
+	}
+
+	q.Run()
+	for q.GetBytes() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Terminate()
+
+	exp := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	if len(done) != len(exp) {
+		t.Fatalf("got %v, expected %v", done, exp)
+	}
+	for i := range exp {
+		if done[i] != exp[i] {
+			t.Fatalf("got %v, expected %v", done, exp)
+		}
+	}
+
+	all, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 0 {
+		t.Errorf("expected no segments left, got %d files", len(all))
+	}
+}
+
+func TestDiskQueueMaxBytes(t *testing.T) {
+	q, err := NewDiskQueue(DiskQueueOpts{Dir: t.TempDir(), MaxWorkers: 2, MaxBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Run()
+
+	var (
+		mu   sync.Mutex
+		done []string
+	)
+	for i := 0; i < 50; i++ {
+		q.Push(&recordJob{v: "0123456789", mu: &mu, done: &done})
+		if q.GetBytes() > 64 {
+			t.Fatalf("bytes: got %d, expected at most 64", q.GetBytes())
+		}
+	}
+	for q.GetBytes() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Terminate()
+
+	if len(done) != 50 {
+		t.Errorf("got %d, expected 50 jobs", len(done))
+	}
+}
+
+type blockingJob struct {
+	recordJob
+	release chan struct{}
+}
+
+func (j *blockingJob) Job() {
+	<-j.release
+	j.recordJob.Job()
+}
+
+func TestDiskQueueTimeout(t *testing.T) {
+	var dropped []Jober
+	q, err := NewDiskQueue(DiskQueueOpts{
+		Dir:        t.TempDir(),
+		MaxWorkers: 1,
+		MaxBytes:   32,
+		Timeout:    50 * time.Millisecond,
+		OnDrop:     func(job Jober) { dropped = append(dropped, job) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Run()
+
+	var (
+		mu   sync.Mutex
+		done []string
+	)
+	first := &blockingJob{
+		recordJob: recordJob{v: "0123456789", mu: &mu, done: &done},
+		release:   make(chan struct{}),
+	}
+	q.Push(first)
+
+	second := &recordJob{v: "0123456789", mu: &mu, done: &done}
+	start := time.Now()
+	q.Push(second)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("push returned after %s, expected to wait for the timeout", elapsed)
+	}
+	if len(dropped) != 1 || dropped[0] != second {
+		t.Fatalf("dropped: got %v, expected the second job", dropped)
+	}
+
+	close(first.release)
+	for q.GetBytes() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Terminate()
+
+	if len(done) != 1 {
+		t.Errorf("got %v, expected the first job only", done)
+	}
+}
@@ -5,6 +5,13 @@ type Jober interface {
 	Job()
 }
 
+// AsyncJober a task that completes after Job returns; queues able to
+// track the completion (i.e. DiskQueue) call JobAsync instead of Job
+type AsyncJober interface {
+	Jober
+	JobAsync(done func())
+}
+
 // SyncJober a synchronization task that can be executed
 type SyncJober interface {
 	Jober
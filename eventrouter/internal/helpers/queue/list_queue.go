@@ -13,9 +13,16 @@ func NewListQueue(maxThread int) *ListQueue {
 }
 
 // NewListQueueWithMaxLen create a list queue that specifies the number of worker threads
-// and the maximum number of elements
+// and the maximum number of elements; the jobs past the maximum are dropped
 func NewListQueueWithMaxLen(maxThread, maxLen int) *ListQueue {
+	return NewListQueueWithOverflow(maxThread, maxLen, Overflow{Policy: OverflowDropNewest})
+}
+
+// NewListQueueWithOverflow create a list queue that specifies the number of worker threads,
+// the maximum number of elements and the behavior once the maximum is reached
+func NewListQueueWithOverflow(maxThread, maxLen int, overflow Overflow) *ListQueue {
 	return &ListQueue{
+		overflow:   overflow,
 		maxLen:     maxLen,
 		maxWorker:  maxThread,
 		workers:    make([]*worker, maxThread),
@@ -29,6 +36,7 @@ func NewListQueueWithMaxLen(maxThread, maxLen int) *ListQueue {
 // ListQueue a list task queue for mitigating server pressure in high concurrency situations
 // and improving task processing
 type ListQueue struct {
+	overflow   Overflow
 	maxLen     int
 	maxWorker  int
 	workers    []*worker
@@ -37,6 +45,7 @@ type ListQueue struct {
 	lock       *sync.RWMutex
 	wg         *sync.WaitGroup
 	running    uint32
+	busy       int32
 }
 
 // Run start running queues
@@ -46,8 +55,12 @@ func (q *ListQueue) Run() {
 	}
 	atomic.StoreUint32(&q.running, 1)
 
+	if q.overflow.Spill != nil {
+		q.overflow.Spill.Run()
+	}
+
 	for i := 0; i < q.maxWorker; i++ {
-		q.workers[i] = newWorker(q.workerPool, q.wg)
+		q.workers[i] = newWorker(q.workerPool, q.wg, &q.busy)
 		q.workers[i].Start()
 	}
 
@@ -61,9 +74,17 @@ func (q *ListQueue) dispatcher() {
 			q.lock.RUnlock()
 			break
 		}
-		ele := q.list.Front()
 		q.lock.RUnlock()
 
+		// the job is removed before waiting for a worker,
+		// so that it can't be dropped by a concurrent Push
+		q.lock.Lock()
+		ele := q.list.Front()
+		if ele != nil {
+			q.list.Remove(ele)
+		}
+		q.lock.Unlock()
+
 		if ele == nil {
 			time.Sleep(time.Millisecond * 10)
 			continue
@@ -71,10 +92,6 @@ func (q *ListQueue) dispatcher() {
 
 		worker := <-q.workerPool
 		worker <- ele.Value.(Jober)
-
-		q.lock.Lock()
-		q.list.Remove(ele)
-		q.lock.Unlock()
 	}
 }
 
@@ -84,19 +101,58 @@ func (q *ListQueue) Push(job Jober) {
 		return
 	}
 
-	if q.maxLen > 0 {
-		q.lock.RLock()
-		if q.list.Len() > q.maxLen {
-			q.lock.RUnlock()
+	if q.maxLen <= 0 {
+		q.wg.Add(1)
+		q.lock.Lock()
+		q.list.PushBack(job)
+		q.lock.Unlock()
+		return
+	}
+
+	var deadline time.Time
+	if q.overflow.Timeout > 0 {
+		deadline = time.Now().Add(q.overflow.Timeout)
+	}
+
+	for {
+		q.lock.Lock()
+		// the job waiting for a worker has already been removed
+		// by the dispatcher: as before, maxLen+1 jobs are held
+		if q.list.Len() < q.maxLen {
+			q.wg.Add(1)
+			q.list.PushBack(job)
+			q.lock.Unlock()
 			return
 		}
-		q.lock.RUnlock()
-	}
 
-	q.wg.Add(1)
-	q.lock.Lock()
-	q.list.PushBack(job)
-	q.lock.Unlock()
+		switch q.overflow.Policy {
+		case OverflowDropOldest:
+			// the new job takes the place (and the wait group slot) of the dropped one
+			old := q.list.Remove(q.list.Front()).(Jober)
+			q.list.PushBack(job)
+			q.lock.Unlock()
+			q.overflow.drop(old)
+			return
+
+		case OverflowSpill:
+			q.lock.Unlock()
+			q.overflow.Spill.Push(job)
+			return
+
+		case OverflowBlock:
+			q.lock.Unlock()
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				q.overflow.drop(job)
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+
+		default:
+			q.lock.Unlock()
+			q.overflow.drop(job)
+			return
+		}
+	}
 }
 
 // Terminate terminate the queue to receive the task and release the resource
@@ -112,4 +168,30 @@ func (q *ListQueue) Terminate() {
 		q.workers[i].Stop()
 	}
 	close(q.workerPool)
+
+	if q.overflow.Spill != nil {
+		q.overflow.Spill.Terminate()
+	}
+}
+
+// GetJobCount returns the number of jobs waiting for a worker
+func (q *ListQueue) GetJobCount() int {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	return q.list.Len()
+}
+
+// Restore restores the jobs persisted by the spill queue, if any
+func (q *ListQueue) Restore(decode func([]byte) (Jober, error)) (int, error) {
+	return q.overflow.restore(decode)
+}
+
+// GetBusyWorkers returns the number of workers running a job
+func (q *ListQueue) GetBusyWorkers() int {
+	return int(atomic.LoadInt32(&q.busy))
+}
+
+// GetMaxWorkers returns the number of worker threads
+func (q *ListQueue) GetMaxWorkers() int {
+	return q.maxWorker
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testJob struct {
@@ -77,3 +78,25 @@ func BenchmarkListQueue(b *testing.B) {
 	})
 	q.Terminate()
 }
+
+func TestListQueueMaxLen(t *testing.T) {
+	q, release := blockingQueue(t, func(Overflow) Queuer {
+		return NewListQueueWithMaxLen(1, 2)
+	}, Overflow{})
+
+	var run int64
+	for i := 0; i < 5; i++ {
+		q.Push(NewJob(i, func(interface{}) {
+			atomic.AddInt64(&run, 1)
+		}))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	close(release)
+	q.Terminate()
+
+	// the job waiting for the busy worker and maxLen more ones
+	if run != 3 {
+		t.Errorf("got %d, expected 3 jobs", run)
+	}
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQueueFull is the reason given to the jobs dropped by a full queue.
+var ErrQueueFull = errors.New("queue full")
+
+// Rejecter is a Jober that must be told when it's dropped
+// without being run, e.g. to record the failure.
+type Rejecter interface {
+	Jober
+	Reject(err error)
+}
+
+// OverflowPolicy tells what Push does when the queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for some room, up to the Overflow timeout
+	// (forever if zero); the job is dropped once the timeout expires.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest drops the oldest queued job to make room.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest drops the job being pushed.
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowSpill pushes the job to the Overflow spill queue.
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// ParseOverflowPolicy validates the name of an overflow policy.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSpill:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid overflow policy %q (valid values: %s, %s, %s, %s)",
+			s, OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSpill)
+	}
+}
+
+// Overflow configures the behavior of a full queue.
+type Overflow struct {
+	Policy OverflowPolicy
+	// Timeout is how long Push blocks with the OverflowBlock policy.
+	Timeout time.Duration
+	// Spill receives the jobs past the queue capacity with the
+	// OverflowSpill policy (e.g. a DiskQueue); it's run and
+	// terminated along with the queue.
+	Spill Queuer
+	// OnDrop is called with each dropped job (optional).
+	OnDrop func(job Jober)
+}
+
+func (o *Overflow) drop(job Jober) {
+	if o.OnDrop != nil {
+		o.OnDrop(job)
+	}
+}
+
+// restore restores the jobs of the spill queue, if it's a Restorer.
+func (o *Overflow) restore(decode func([]byte) (Jober, error)) (int, error) {
+	if r, ok := o.Spill.(Restorer); ok {
+		return r.Restore(decode)
+	}
+	return 0, nil
+}
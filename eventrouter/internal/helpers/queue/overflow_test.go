@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingQueue returns a queue whose only worker is busy until
+// release is closed, so that the pushed jobs pile up.
+func blockingQueue(t *testing.T, newQueue func(Overflow) Queuer, overflow Overflow) (Queuer, chan struct{}) {
+	t.Helper()
+
+	q := newQueue(overflow)
+	q.Run()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.Push(NewJob(nil, func(interface{}) {
+		close(started)
+		<-release
+	}))
+	<-started
+
+	return q, release
+}
+
+func TestOverflow(t *testing.T) {
+	queues := map[string]func(Overflow) Queuer{
+		"Queue": func(o Overflow) Queuer {
+			return NewQueueWithOverflow(2, 1, o)
+		},
+		"ListQueue": func(o Overflow) Queuer {
+			return NewListQueueWithOverflow(1, 2, o)
+		},
+	}
+
+	tests := []struct {
+		policy     OverflowPolicy
+		timeout    time.Duration
+		expDropped []int
+		expRun     []int
+	}{
+		{OverflowDropNewest, 0, []int{4, 5}, []int{1, 2, 3}},
+		{OverflowDropOldest, 0, []int{2, 3}, []int{1, 4, 5}},
+		{OverflowBlock, 10 * time.Millisecond, []int{4, 5}, []int{1, 2, 3}},
+	}
+
+	for name, newQueue := range queues {
+		for _, tc := range tests {
+			t.Run(name+"/"+string(tc.policy), func(t *testing.T) {
+				var (
+					mu      sync.Mutex
+					dropped []int
+					run     []int
+				)
+
+				q, release := blockingQueue(t, newQueue, Overflow{
+					Policy:  tc.policy,
+					Timeout: tc.timeout,
+					OnDrop: func(j Jober) {
+						mu.Lock()
+						dropped = append(dropped, j.(*job).v.(int))
+						mu.Unlock()
+					},
+				})
+
+				// the first job is held by the dispatcher waiting for
+				// the busy worker, the next two fill up the queue
+				for i := 1; i <= 5; i++ {
+					q.Push(NewJob(i, func(v interface{}) {
+						mu.Lock()
+						run = append(run, v.(int))
+						mu.Unlock()
+					}))
+					time.Sleep(20 * time.Millisecond)
+				}
+
+				close(release)
+				q.Terminate()
+
+				if !equalInts(dropped, tc.expDropped) {
+					t.Errorf("dropped: got %v, expected %v", dropped, tc.expDropped)
+				}
+				if !equalInts(run, tc.expRun) {
+					t.Errorf("run: got %v, expected %v", run, tc.expRun)
+				}
+			})
+		}
+	}
+}
+
+func TestOverflowSpill(t *testing.T) {
+	spill, err := NewDiskQueue(DiskQueueOpts{Dir: t.TempDir(), MaxWorkers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, release := blockingQueue(t, func(o Overflow) Queuer {
+		return NewQueueWithOverflow(1, 1, o)
+	}, Overflow{Policy: OverflowSpill, Spill: spill})
+
+	var count int64
+	for i := 0; i < 5; i++ {
+		q.Push(NewJob(i, func(interface{}) {
+			atomic.AddInt64(&count, 1)
+		}))
+	}
+
+	for spill.GetBytes() > 0 || spill.GetJobCount() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	q.Terminate()
+
+	if count != 5 {
+		t.Errorf("got %d, expected 5 jobs", count)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
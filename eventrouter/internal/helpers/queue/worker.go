@@ -2,13 +2,15 @@ package queue
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 // create a worker thread
-func newWorker(pool chan chan Jober, wg *sync.WaitGroup) *worker {
+func newWorker(pool chan chan Jober, wg *sync.WaitGroup, busy *int32) *worker {
 	return &worker{
 		pool:    pool,
 		wg:      wg,
+		busy:    busy,
 		jobChan: make(chan Jober),
 		quit:    make(chan struct{}),
 	}
@@ -18,6 +20,7 @@ func newWorker(pool chan chan Jober, wg *sync.WaitGroup) *worker {
 type worker struct {
 	pool    chan chan Jober
 	wg      *sync.WaitGroup
+	busy    *int32
 	jobChan chan Jober
 	quit    chan struct{}
 }
@@ -32,7 +35,9 @@ func (w *worker) dispatcher() {
 	for {
 		select {
 		case j := <-w.jobChan:
+			atomic.AddInt32(w.busy, 1)
 			j.Job()
+			atomic.AddInt32(w.busy, -1)
 			w.pool <- w.jobChan
 			w.wg.Done()
 		case <-w.quit:
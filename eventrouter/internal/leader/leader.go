@@ -0,0 +1,102 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	inClusterNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+type ElectorOpts struct {
+	Client kubernetes.Interface
+	// LeaseName is the name of the Lease object used as lock.
+	LeaseName string
+	// LeaseNamespace is the namespace of the Lease object; when empty
+	// the namespace of the pod service account is used.
+	LeaseNamespace string
+	// Identity of this candidate (default: the hostname).
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Run blocks until the context is cancelled, calling run once this
+// candidate acquires the Lease. The context passed to run is cancelled
+// when the leadership is lost; the lease is released on cancellation
+// so that a standby can take over immediately.
+func Run(ctx context.Context, opts ElectorOpts, run func(ctx context.Context)) error {
+	id := opts.Identity
+	if len(id) == 0 {
+		var err error
+		id, err = os.Hostname()
+		if err != nil {
+			return fmt.Errorf("cannot get leader election identity: %w", err)
+		}
+	}
+
+	ns := opts.LeaseNamespace
+	if len(ns) == 0 {
+		var err error
+		ns, err = PodNamespace()
+		if err != nil {
+			return fmt.Errorf("cannot detect leader election namespace: %w", err)
+		}
+	}
+
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock,
+		ns, opts.LeaseName,
+		opts.Client.CoreV1(), opts.Client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: id})
+	if err != nil {
+		return fmt.Errorf("cannot create leader election lock: %w", err)
+	}
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            opts.LeaseName,
+		LeaseDuration:   opts.LeaseDuration,
+		RenewDeadline:   opts.RenewDeadline,
+		RetryPeriod:     opts.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.InfoS("leadership acquired", "lease", opts.LeaseName, "identity", id)
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				klog.InfoS("leadership lost", "lease", opts.LeaseName, "identity", id)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					klog.InfoS("waiting for leadership", "lease", opts.LeaseName, "leader", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create leader elector: %w", err)
+	}
+
+	le.Run(ctx)
+	return nil
+}
+
+// PodNamespace returns the namespace of the pod service account.
+func PodNamespace() (string, error) {
+	dat, err := os.ReadFile(inClusterNamespacePath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(dat)), nil
+}
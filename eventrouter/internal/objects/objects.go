@@ -93,10 +93,11 @@ func (r *ObjectResolver) ResolveReference(ctx context.Context, ref *corev1.Objec
 }
 
 type PatchOpts struct {
-	PatchData []byte
-	GVK       schema.GroupVersionKind
-	Name      string
-	Namespace string
+	PatchData    []byte
+	GVK          schema.GroupVersionKind
+	Name         string
+	Namespace    string
+	Subresources []string
 }
 
 func (r *ObjectResolver) Patch(ctx context.Context, opts PatchOpts) error {
@@ -112,7 +113,7 @@ func (r *ObjectResolver) Patch(ctx context.Context, opts PatchOpts) error {
 
 	_, err = dri.Patch(ctx, opts.Name, types.MergePatchType, opts.PatchData, metav1.PatchOptions{
 		FieldManager: "krateo",
-	})
+	}, opts.Subresources...)
 	if err != nil {
 		if !apierrors.IsNotFound(err) {
 			return err
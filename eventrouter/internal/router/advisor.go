@@ -4,55 +4,239 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"github.com/krateoplatformops/eventrouter/internal/deadletter"
+	"github.com/krateoplatformops/eventrouter/internal/helpers/queue"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
 type advOpts struct {
 	httpClient       *http.Client
+	registrationName string
 	registrationSpec v1alpha1.RegistrationSpec
-	eventInfo        corev1.Event
+	events           []corev1.Event
+	compositionIdKey string
+	deadLetters      deadletter.Sink
+	stats            *deliveryStats
+	auth             *authenticator
+	lanes            *lanes
+	// attempts is how many delivery attempts have already been
+	// made, when the notification is a replayed dead letter
+	attempts int
+	// registrations (optional) resolves the Registration spec when
+	// the notification is run, i.e. it has been restored
+	registrations *RegistrationCache
 }
 
 func newAdvisor(opts advOpts) *advisor {
 	return &advisor{
-		httpClient: opts.httpClient,
-		reg:        opts.registrationSpec,
-		evt:        opts.eventInfo,
+		httpClient:       opts.httpClient,
+		name:             opts.registrationName,
+		reg:              opts.registrationSpec,
+		events:           opts.events,
+		compositionIdKey: opts.compositionIdKey,
+		deadLetters:      opts.deadLetters,
+		stats:            opts.stats,
+		auth:             opts.auth,
+		lanes:            opts.lanes,
+		attempts:         opts.attempts,
+		registrations:    opts.registrations,
 	}
 }
 
 type advisor struct {
-	httpClient *http.Client
-	reg        v1alpha1.RegistrationSpec
-	evt        corev1.Event
+	httpClient       *http.Client
+	name             string
+	reg              v1alpha1.RegistrationSpec
+	events           []corev1.Event
+	compositionIdKey string
+	deadLetters      deadletter.Sink
+	stats            *deliveryStats
+	auth             *authenticator
+	lanes            *lanes
+	attempts         int
+	registrations    *RegistrationCache
 }
 
+var (
+	_ queue.AsyncJober = (*advisor)(nil)
+	_ queue.Rejecter   = (*advisor)(nil)
+)
+
+// Job hands the notification to the Registration lane.
 func (c *advisor) Job() {
-	err := c.notify()
-	if err != nil {
-		klog.Errorf("unable to notify %s: %s", c.reg.ServiceName, err.Error())
+	c.JobAsync(nil)
+}
+
+// JobAsync hands the notification to the Registration lane;
+// done is called once the notification has been handled.
+func (c *advisor) JobAsync(done func()) {
+	if !c.resolve() {
+		c.reject(errRegistrationNotFound)
+		if done != nil {
+			done()
+		}
+		return
+	}
+
+	if c.lanes == nil {
+		c.deliver(nil)
+		if done != nil {
+			done()
+		}
+		return
 	}
+
+	c.lanes.submit(c, done)
 }
 
-func (c *advisor) notify() error {
-	compositionId := ""
-	if labels := c.evt.GetLabels(); len(labels) > 0 {
-		compositionId = labels[keyCompositionID]
+// resolve looks up the Registration spec of a restored
+// notification; it's false if the Registration is gone.
+func (c *advisor) resolve() bool {
+	if c.registrations == nil {
+		return true
+	}
+
+	el, ok := c.registrations.get(c.name)
+	if !ok {
+		return false
+	}
+
+	c.reg = el.spec
+	c.registrations = nil
+	return true
+}
+
+// deliver sends the notification, retrying the failed attempts;
+// it returns the error of the last attempt. Once stop is closed,
+// the notification is no longer retried and it's dead-lettered.
+func (c *advisor) deliver(stop <-chan struct{}) error {
+	backoff := retryBackoff(c.reg.Retry)
+	maxAttempts := backoff.Steps
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		start := time.Now()
+		err = c.notify()
+		deliveryDuration.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+		if err == nil {
+			c.stats.success(c.name, len(c.events))
+			eventsDelivered.WithLabelValues(c.name).Add(float64(len(c.events)))
+			return nil
+		}
+		deliveryFailures.WithLabelValues(c.name).Inc()
+
+		if !isRetryable(err) || attempt >= maxAttempts {
+			break
+		}
+
+		delay := retryDelay(&backoff, err)
+
+		klog.V(4).InfoS("notification failed, retrying",
+			"registration", c.name,
+			"attempt", attempt,
+			"delay", delay,
+			"err", err.Error())
+
+		if !sleep(delay, stop) {
+			klog.V(4).InfoS("notification retry interrupted",
+				"registration", c.name,
+				"attempt", attempt)
+			break
+		}
+	}
+
+	klog.Errorf("unable to notify %s (attempts: %d): %s", c.reg.ServiceName, attempt, err.Error())
+
+	c.stats.failure(c.name, len(c.events), err)
+	eventsUndelivered.WithLabelValues(c.name).Add(float64(len(c.events)))
+	c.deadLetter(attempt, err)
+
+	return err
+}
+
+// sleep waits for the delay; it returns false if stop
+// is closed before the delay is over.
+func sleep(delay time.Duration, stop <-chan struct{}) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Reject gives up the notification dropped by the queue.
+func (c *advisor) Reject(err error) {
+	c.reject(err)
+}
+
+// reject gives up the notification without attempting its delivery;
+// the rejection counts as an attempt, so that the replayed dead
+// letters rejected again eventually exceed the maximum attempts.
+func (c *advisor) reject(err error) {
+	klog.V(4).InfoS("notification rejected",
+		"registration", c.name,
+		"err", err.Error())
+
+	c.stats.failure(c.name, len(c.events), err)
+	eventsUndelivered.WithLabelValues(c.name).Add(float64(len(c.events)))
+	c.deadLetter(1, err)
+}
+
+func (c *advisor) deadLetter(attempts int, lastErr error) {
+	if c.deadLetters == nil {
+		return
 	}
 
-	dat, err := json.Marshal(c.evt)
+	for _, evt := range c.events {
+		err := c.deadLetters.Put(deadletter.Entry{
+			Registration: c.name,
+			Endpoint:     c.reg.Endpoint,
+			Attempts:     c.attempts + attempts,
+			LastError:    lastErr.Error(),
+			Time:         time.Now(),
+			Event:        evt,
+		})
+		if err != nil {
+			klog.ErrorS(err, "unable to store dead letter",
+				"registration", c.name, "event", evt.Name)
+		}
+	}
+}
+
+// encode returns the notification body and headers; when batching is
+// enabled all the events are sent at once, even if there is just one.
+func (c *advisor) encode() ([]byte, http.Header, error) {
+	if c.reg.Batch != nil {
+		return encodeBatch(c.reg.Format, c.reg.Batch.Encoding, c.events, c.compositionIdKey)
+	}
+
+	evt := &c.events[0]
+	return encodeNotification(c.reg.Format, evt, compositionIdOf(evt, c.compositionIdKey))
+}
+
+func (c *advisor) notify() error {
+	compositionId := compositionIdOf(&c.events[0], c.compositionIdKey)
+
+	dat, hdr, err := c.encode()
 	if err != nil {
 		return fmt.Errorf("cannot encode notification (compositionId:%s, destinationURL:%s): %w",
 			compositionId, c.reg.Endpoint, err)
 	}
 
-	ctx, cncl := context.WithTimeout(context.Background(), time.Second*40)
+	ctx, cncl := context.WithTimeout(context.Background(), deliveryTimeout(c.reg.Delivery))
 	defer cncl()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.reg.Endpoint, bytes.NewBuffer(dat))
@@ -61,12 +245,64 @@ func (c *advisor) notify() error {
 			compositionId, c.reg.Endpoint, err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	_, err = c.httpClient.Do(req)
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+
+	httpClient := c.httpClient
+	if c.reg.Auth != nil && c.auth != nil {
+		creds, err := c.auth.credentials(c.name, c.reg.Auth)
+		if err != nil {
+			err = fmt.Errorf("cannot load credentials (compositionId:%s, destinationURL:%s): %w",
+				compositionId, c.reg.Endpoint, err)
+			if errors.Is(err, errSecretNamespace) {
+				return err
+			}
+			return &deliveryError{err: err}
+		}
+
+		creds.sign(req, dat, time.Now())
+		if creds.httpClient != nil {
+			httpClient = creds.httpClient
+		}
+	}
+
+	res, err := httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("cannot send notification (deploymentId:%s, destinationURL:%s): %w",
-			compositionId, c.reg.Endpoint, err)
+		return &deliveryError{
+			err: fmt.Errorf("cannot send notification (compositionId:%s, destinationURL:%s): %w",
+				compositionId, c.reg.Endpoint, err),
+		}
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return &deliveryError{
+			statusCode: res.StatusCode,
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+			err: fmt.Errorf("notification rejected (compositionId:%s, destinationURL:%s): status %d",
+				compositionId, c.reg.Endpoint, res.StatusCode),
+		}
 	}
 
 	return nil
 }
+
+// storedAdvisor is the advisor state persisted by the disk queue;
+// the Registration spec is looked up again once restored.
+type storedAdvisor struct {
+	Registration string         `json:"registration"`
+	Events       []corev1.Event `json:"events"`
+	Attempts     int            `json:"attempts,omitempty"`
+}
+
+var _ queue.Marshaler = (*advisor)(nil)
+
+func (c *advisor) MarshalJob() ([]byte, error) {
+	return json.Marshal(storedAdvisor{
+		Registration: c.name,
+		Events:       c.events,
+		Attempts:     c.attempts,
+	})
+}
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdvisorRestore(t *testing.T) {
+	job := newAdvisor(advOpts{
+		registrationName: "httpecho-registration",
+		registrationSpec: v1alpha1.RegistrationSpec{
+			ServiceName: "HTTP Echo",
+			Endpoint:    "http://127.0.0.1:9090/handle",
+		},
+		events: []corev1.Event{{
+			ObjectMeta: metav1.ObjectMeta{Name: "fake-event-1", UID: "uid-1"},
+			Reason:     "LoremIpsum",
+		}},
+		compositionIdKey: DefaultCompositionIDKey,
+	})
+
+	dat, err := job.MarshalJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(dat), job.reg.Endpoint) {
+		t.Errorf("expected only the registration name to be persisted: %s", dat)
+	}
+
+	p := &pusher{
+		compositionIdKey: DefaultCompositionIDKey,
+		stats:            newDeliveryStats(),
+		registrations: &RegistrationCache{items: map[string]registration{
+			job.name: {name: job.name, spec: job.reg},
+		}},
+	}
+	res, err := p.restore(dat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := res.(*advisor)
+	if !ok {
+		t.Fatalf("got %T, expected *advisor", res)
+	}
+	if !got.resolve() {
+		t.Fatal("expected the registration to be resolved")
+	}
+	if got.name != job.name || got.reg.Endpoint != job.reg.Endpoint {
+		t.Errorf("registration: got %q (%s), expected %q (%s)",
+			got.name, got.reg.Endpoint, job.name, job.reg.Endpoint)
+	}
+	if len(got.events) != 1 || got.events[0].UID != "uid-1" {
+		t.Errorf("events: got %v", got.events)
+	}
+	if got.stats != p.stats {
+		t.Error("expected the pusher delivery stats")
+	}
+
+	if _, err := p.restore([]byte(`{"registration":"foo"}`)); err == nil {
+		t.Error("expected error without events")
+	}
+
+	// the Registration deleted while the notification was pending
+	p.registrations.remove(job.name)
+	res, err = p.restore(dat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &mockSink{}
+	got = res.(*advisor)
+	got.deadLetters = sink
+	got.JobAsync(nil)
+
+	if len(sink.all) != 1 || sink.all[0].LastError != errRegistrationNotFound.Error() {
+		t.Errorf("dead letters: got %v", sink.all)
+	}
+}
@@ -0,0 +1,245 @@
+package router
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	httpHelper "github.com/krateoplatformops/eventrouter/internal/helpers/http"
+	"github.com/krateoplatformops/eventrouter/internal/objects"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	secretKeyToken   = "token"
+	secretKeyHMAC    = "hmacKey"
+	secretKeyTLSCert = corev1.TLSCertKey
+	secretKeyTLSKey  = corev1.TLSPrivateKeyKey
+	secretKeyCA      = "ca.crt"
+
+	headerSignature = "X-Krateo-Signature"
+	headerTimestamp = "X-Krateo-Timestamp"
+
+	credentialsTTL = time.Minute
+
+	// AnyNamespace allows the Secrets of every namespace.
+	AnyNamespace = "*"
+)
+
+// errSecretNamespace is returned for the Secrets outside
+// of the allowed namespaces; it's not worth retrying.
+var errSecretNamespace = errors.New("secret namespace not allowed")
+
+// credentials are the delivery credentials of a Registration.
+type credentials struct {
+	token      string
+	hmacKey    []byte
+	httpClient *http.Client
+}
+
+// sign sets the authentication headers of the request; the
+// HMAC signature covers the timestamp and the request body.
+func (c *credentials) sign(req *http.Request, body []byte, now time.Time) {
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	if len(c.hmacKey) > 0 {
+		ts := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set(headerTimestamp, ts)
+		req.Header.Set(headerSignature, "sha256="+computeSignature(c.hmacKey, ts, body))
+	}
+}
+
+// close releases the idle connections of the HTTP client, once
+// the credentials have been replaced or discarded.
+func (c *credentials) close() {
+	if c.httpClient != nil {
+		c.httpClient.CloseIdleConnections()
+	}
+}
+
+// computeSignature returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>".
+func computeSignature(key []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type credentialsEntry struct {
+	secret          v1alpha1.SecretReference
+	resourceVersion string
+	expires         time.Time
+	creds           *credentials
+}
+
+// authenticator loads and caches the Registrations credentials.
+// Secrets are read again once their cache entry expires; the HTTP
+// client is rebuilt only when the Secret has changed. Only the
+// Secrets of the allowed namespaces are read, since any Registration
+// could otherwise send the credentials of another one to its endpoint.
+type authenticator struct {
+	resolver   *objects.ObjectResolver
+	verbose    bool
+	insecure   bool
+	namespaces []string
+
+	mu    sync.Mutex
+	items map[string]credentialsEntry
+}
+
+func newAuthenticator(resolver *objects.ObjectResolver, verbose, insecure bool, namespaces []string) *authenticator {
+	return &authenticator{
+		resolver:   resolver,
+		verbose:    verbose,
+		insecure:   insecure,
+		namespaces: namespaces,
+		items:      map[string]credentialsEntry{},
+	}
+}
+
+// allowed reports whether the Secrets of the namespace can be read.
+func (a *authenticator) allowed(ns string) bool {
+	for _, el := range a.namespaces {
+		if el == AnyNamespace || el == ns {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *authenticator) credentials(name string, auth *v1alpha1.RegistrationAuth) (*credentials, error) {
+	if !a.allowed(auth.SecretRef.Namespace) {
+		return nil, fmt.Errorf("%w: %s/%s", errSecretNamespace,
+			auth.SecretRef.Namespace, auth.SecretRef.Name)
+	}
+
+	a.mu.Lock()
+	el, ok := a.items[name]
+	a.mu.Unlock()
+
+	if ok && el.secret == auth.SecretRef && time.Now().Before(el.expires) {
+		return el.creds, nil
+	}
+
+	secret, err := a.getSecret(auth.SecretRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok || el.secret != auth.SecretRef || el.resourceVersion != secret.ResourceVersion {
+		el.creds, err = credentialsFromSecret(secret, a.verbose, a.insecure)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	el.secret = auth.SecretRef
+	el.resourceVersion = secret.ResourceVersion
+	el.expires = time.Now().Add(credentialsTTL)
+
+	a.mu.Lock()
+	prev, ok := a.items[name]
+	a.items[name] = el
+	a.mu.Unlock()
+
+	if ok && prev.creds != el.creds {
+		prev.creds.close()
+	}
+
+	return el.creds, nil
+}
+
+// prune discards the credentials of the deleted Registrations.
+func (a *authenticator) prune(all map[string]registration) {
+	var gone []*credentials
+
+	a.mu.Lock()
+	for name, el := range a.items {
+		if _, ok := all[name]; !ok {
+			gone = append(gone, el.creds)
+			delete(a.items, name)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, el := range gone {
+		el.close()
+	}
+}
+
+func (a *authenticator) getSecret(ref v1alpha1.SecretReference) (*corev1.Secret, error) {
+	obj, err := a.resolver.ResolveReference(context.Background(), &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Name:       ref.Name,
+		Namespace:  ref.Namespace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("secret %s/%s not found", ref.Namespace, ref.Name)
+	}
+
+	var secret corev1.Secret
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+
+	return &secret, nil
+}
+
+func credentialsFromSecret(secret *corev1.Secret, verbose, insecure bool) (*credentials, error) {
+	res := &credentials{
+		token:   string(secret.Data[secretKeyToken]),
+		hmacKey: secret.Data[secretKeyHMAC],
+	}
+
+	crt, key, ca := secret.Data[secretKeyTLSCert], secret.Data[secretKeyTLSKey], secret.Data[secretKeyCA]
+	if len(crt) == 0 && len(key) == 0 && len(ca) == 0 {
+		return res, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if len(crt) > 0 || len(key) > 0 {
+		cert, err := tls.X509KeyPair(crt, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate in secret %s/%s: %w",
+				secret.Namespace, secret.Name, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid CA bundle in secret %s/%s",
+				secret.Namespace, secret.Name)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	res.httpClient = httpHelper.ClientFromOpts(httpHelper.ClientOpts{
+		Verbose:   verbose,
+		Insecure:  insecure,
+		TLSConfig: tlsConfig,
+	})
+
+	return res, nil
+}
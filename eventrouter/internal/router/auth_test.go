@@ -0,0 +1,204 @@
+package router
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCredentialsSign(t *testing.T) {
+	creds, err := credentialsFromSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "eventsse-credentials",
+			Namespace: "demo-system",
+		},
+		Data: map[string][]byte{
+			secretKeyToken: []byte("s3cr3t"),
+			secretKeyHMAC:  []byte("k3y"),
+		},
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if creds.httpClient != nil {
+		t.Error("expected the default http client without TLS material")
+	}
+
+	body := []byte(`{"reason":"Test"}`)
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:9090/handle", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1720164789, 0)
+	creds.sign(req, body, now)
+
+	if got := req.Header.Get("Authorization"); got != "Bearer s3cr3t" {
+		t.Errorf("authorization: got %q, expected %q", got, "Bearer s3cr3t")
+	}
+
+	if got := req.Header.Get(headerTimestamp); got != "1720164789" {
+		t.Errorf("timestamp: got %q, expected %q", got, "1720164789")
+	}
+
+	exp := "sha256=" + computeSignature([]byte("k3y"), "1720164789", body)
+	if got := req.Header.Get(headerSignature); got != exp {
+		t.Errorf("signature: got %q, expected %q", got, exp)
+	}
+}
+
+func TestCredentialsInvalidTLS(t *testing.T) {
+	_, err := credentialsFromSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			secretKeyCA: []byte("not a pem"),
+		},
+	}, false, false)
+	if err == nil {
+		t.Fatal("expected error with invalid CA bundle")
+	}
+}
+
+func TestCredentialsClose(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	for _, verbose := range []bool{false, true} {
+		creds, err := credentialsFromSecret(&corev1.Secret{
+			Data: map[string][]byte{
+				secretKeyCA: ca,
+			},
+		}, verbose, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := creds.httpClient.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		creds.close()
+
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("verbose %t: expected the idle connection to be closed", verbose)
+		}
+	}
+}
+
+func TestAuthenticatorPrune(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	creds, err := credentialsFromSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			secretKeyCA: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}),
+		},
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := creds.httpClient.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	a := newAuthenticator(nil, false, false, nil)
+	a.items["foo"] = credentialsEntry{creds: &credentials{token: "foo"}}
+	a.items["bar"] = credentialsEntry{creds: creds}
+
+	// bar has been deleted
+	a.prune(map[string]registration{"foo": {}})
+
+	if len(a.items) != 1 || a.items["foo"].creds == nil {
+		t.Errorf("credentials: got %v, expected only foo", a.items)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the idle connection to be closed")
+	}
+}
+
+func TestAuthenticatorSecretNamespace(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	// the resolver is not used for the rejected secrets
+	a := newAuthenticator(nil, false, false, []string{"eventrouter-system"})
+	sink := &mockSink{}
+	stats := newDeliveryStats()
+
+	adv := newAdvisor(advOpts{
+		httpClient:       srv.Client(),
+		registrationName: "test",
+		registrationSpec: v1alpha1.RegistrationSpec{
+			Endpoint: srv.URL,
+			Auth: &v1alpha1.RegistrationAuth{
+				SecretRef: v1alpha1.SecretReference{Name: "creds", Namespace: "kube-system"},
+			},
+		},
+		events:      []corev1.Event{{Reason: "Test"}},
+		deadLetters: sink,
+		stats:       stats,
+		auth:        a,
+	})
+
+	err := adv.deliver(nil)
+	if !errors.Is(err, errSecretNamespace) {
+		t.Fatalf("got %v, expected %v", err, errSecretNamespace)
+	}
+	if calls != 0 {
+		t.Errorf("endpoint called %d times, expected none", calls)
+	}
+	if len(sink.all) != 1 || sink.all[0].Attempts != 1 {
+		t.Errorf("expected a dead letter after a single attempt, got %+v", sink.all)
+	}
+
+	if got := stats.drain()["test"].lastReason; got != reasonSecretRejected {
+		t.Errorf("reason: got %q, expected %q", got, reasonSecretRejected)
+	}
+
+	for ns, want := range map[string]bool{"eventrouter-system": true, "kube-system": false} {
+		if got := a.allowed(ns); got != want {
+			t.Errorf("allowed(%q): got %v, expected %v", ns, got, want)
+		}
+	}
+	if !newAuthenticator(nil, false, false, []string{AnyNamespace}).allowed("kube-system") {
+		t.Errorf("expected any namespace allowed with %q", AnyNamespace)
+	}
+}
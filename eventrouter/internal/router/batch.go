@@ -0,0 +1,251 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	cloudEventsBatchContentType = "application/cloudevents-batch+json"
+	ndjsonContentType           = "application/x-ndjson"
+
+	defaultBatchMaxLinger = time.Second
+)
+
+// encodeBatch returns the body and the headers of a batch of notifications.
+// With a CloudEvents format every event is wrapped in a structured CloudEvent,
+// since the binary content mode cannot carry more than one event.
+func encodeBatch(format v1alpha1.PayloadFormat, encoding v1alpha1.BatchEncoding, events []corev1.Event, compositionIdKey string) ([]byte, http.Header, error) {
+	cloudEvents := format == v1alpha1.PayloadFormatCloudEventsBinary ||
+		format == v1alpha1.PayloadFormatCloudEventsStructured
+
+	items := make([]json.RawMessage, 0, len(events))
+	for i := range events {
+		evt := &events[i]
+
+		dat, err := json.Marshal(evt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if cloudEvents {
+			dat, err = encodeStructured(evt, compositionIdOf(evt, compositionIdKey), dat)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		items = append(items, dat)
+	}
+
+	hdr := http.Header{}
+
+	if encoding == v1alpha1.BatchEncodingNDJSON {
+		var buf bytes.Buffer
+		for _, el := range items {
+			buf.Write(el)
+			buf.WriteByte('\n')
+		}
+		hdr.Set("Content-Type", ndjsonContentType)
+		return buf.Bytes(), hdr, nil
+	}
+
+	dat, err := json.Marshal(items)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cloudEvents {
+		hdr.Set("Content-Type", cloudEventsBatchContentType)
+	} else {
+		hdr.Set("Content-Type", jsonContentType)
+	}
+
+	return dat, hdr, nil
+}
+
+// batcher groups the events of a Registration; a batch is flushed
+// when it reaches the maximum size or when its oldest event has
+// been waiting for the maximum linger time.
+type batcher struct {
+	spec  v1alpha1.RegistrationSpec
+	flush func(spec v1alpha1.RegistrationSpec, events []corev1.Event)
+
+	mu      sync.Mutex
+	pending []corev1.Event
+	timer   *time.Timer
+	// closed is set once the batcher is no longer tracked:
+	// the events are no longer accepted
+	closed bool
+}
+
+func newBatcher(spec v1alpha1.RegistrationSpec, flush func(v1alpha1.RegistrationSpec, []corev1.Event)) *batcher {
+	return &batcher{
+		spec:  spec,
+		flush: flush,
+	}
+}
+
+// add appends the event to the batch; it returns false if the
+// batcher has been closed. A full batch is flushed out of the
+// lock, since the flush may block.
+func (b *batcher) add(evt corev1.Event) bool {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return false
+	}
+
+	b.pending = append(b.pending, evt)
+
+	var events []corev1.Event
+	if len(b.pending) >= int(b.spec.Batch.MaxSize) {
+		events = b.take()
+	} else if b.timer == nil {
+		linger := defaultBatchMaxLinger
+		if b.spec.Batch.MaxLinger != nil && b.spec.Batch.MaxLinger.Duration > 0 {
+			linger = b.spec.Batch.MaxLinger.Duration
+		}
+		b.timer = time.AfterFunc(linger, b.linger)
+	}
+	b.mu.Unlock()
+
+	if len(events) > 0 {
+		b.flush(b.spec, events)
+	}
+	return true
+}
+
+// linger flushes the pending events once the linger time is over.
+func (b *batcher) linger() {
+	b.mu.Lock()
+	events := b.take()
+	b.mu.Unlock()
+
+	if len(events) > 0 {
+		b.flush(b.spec, events)
+	}
+}
+
+// close refuses the new events and flushes the pending ones.
+func (b *batcher) close() {
+	if events := b.stop(); len(events) > 0 {
+		b.flush(b.spec, events)
+	}
+}
+
+// stop refuses the new events and returns the pending ones.
+func (b *batcher) stop() []corev1.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	return b.take()
+}
+
+// take stops the linger timer and returns the pending
+// events; it must be called with the lock held.
+func (b *batcher) take() []corev1.Event {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	events := b.pending
+	b.pending = nil
+	return events
+}
+
+// batchers holds a batcher for each Registration with batching enabled.
+type batchers struct {
+	flush func(name string, spec v1alpha1.RegistrationSpec, events []corev1.Event)
+
+	mu    sync.Mutex
+	items map[string]*batcher
+	// stopped is set once the pending batches have been
+	// flushed on termination: the events are no longer batched
+	stopped bool
+}
+
+func newBatchers(flush func(string, v1alpha1.RegistrationSpec, []corev1.Event)) *batchers {
+	return &batchers{
+		flush: flush,
+		items: map[string]*batcher{},
+	}
+}
+
+// add appends the event to the Registration batch; when the
+// Registration spec has changed the pending batch is flushed
+// and a new one is started with the new spec.
+//
+// The event is flushed on its own when the batching is stopped, or
+// when its batcher is closed meanwhile (i.e. by prune or stop).
+func (b *batchers) add(name string, spec v1alpha1.RegistrationSpec, evt corev1.Event) {
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		b.flush(name, spec, []corev1.Event{evt})
+		return
+	}
+
+	var prev *batcher
+	el, ok := b.items[name]
+	if !ok || !reflect.DeepEqual(el.spec, spec) {
+		prev = el
+		el = newBatcher(spec, func(spec v1alpha1.RegistrationSpec, events []corev1.Event) {
+			b.flush(name, spec, events)
+		})
+		b.items[name] = el
+	}
+	b.mu.Unlock()
+
+	// out of the lock, the flush may block
+	if prev != nil {
+		prev.close()
+	}
+
+	if !el.add(evt) {
+		b.flush(name, spec, []corev1.Event{evt})
+	}
+}
+
+// prune discards the batchers of the deleted Registrations,
+// handing their pending events to drop.
+func (b *batchers) prune(all map[string]registration, drop func(string, v1alpha1.RegistrationSpec, []corev1.Event)) {
+	gone := map[string]*batcher{}
+
+	b.mu.Lock()
+	for name, el := range b.items {
+		if _, ok := all[name]; !ok {
+			gone[name] = el
+			delete(b.items, name)
+		}
+	}
+	b.mu.Unlock()
+
+	for name, el := range gone {
+		if events := el.stop(); len(events) > 0 {
+			drop(name, el.spec, events)
+		}
+	}
+}
+
+// stop flushes all the pending batches.
+func (b *batchers) stop() {
+	b.mu.Lock()
+	items := b.items
+	b.items = map[string]*batcher{}
+	b.stopped = true
+	b.mu.Unlock()
+
+	for _, el := range items {
+		el.close()
+	}
+}
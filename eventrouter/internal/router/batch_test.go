@@ -0,0 +1,229 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEncodeBatch(t *testing.T) {
+	events := []corev1.Event{
+		{ObjectMeta: metav1.ObjectMeta{Name: "fake-event-1", UID: "uid-1"}, Reason: "Created"},
+		{ObjectMeta: metav1.ObjectMeta{Name: "fake-event-2", UID: "uid-2"}, Reason: "Deleted"},
+	}
+
+	tests := []struct {
+		name        string
+		format      v1alpha1.PayloadFormat
+		encoding    v1alpha1.BatchEncoding
+		contentType string
+		cloudEvents bool
+	}{
+		{"JSON array", "", v1alpha1.BatchEncodingJSON, jsonContentType, false},
+		{"CloudEvents batch", v1alpha1.PayloadFormatCloudEventsStructured, "", cloudEventsBatchContentType, true},
+		{"NDJSON", "", v1alpha1.BatchEncodingNDJSON, ndjsonContentType, false},
+		{"CloudEvents NDJSON", v1alpha1.PayloadFormatCloudEventsBinary, v1alpha1.BatchEncodingNDJSON, ndjsonContentType, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dat, hdr, err := encodeBatch(tc.format, tc.encoding, events, DefaultCompositionIDKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := hdr.Get("Content-Type"); got != tc.contentType {
+				t.Errorf("content type: got %q, expected %q", got, tc.contentType)
+			}
+
+			var items []json.RawMessage
+			if tc.encoding == v1alpha1.BatchEncodingNDJSON {
+				for _, line := range bytes.Split(bytes.TrimSpace(dat), []byte("\n")) {
+					items = append(items, line)
+				}
+			} else if err := json.Unmarshal(dat, &items); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(items) != len(events) {
+				t.Fatalf("items: got %d, expected %d", len(items), len(events))
+			}
+
+			for i, el := range items {
+				var got struct {
+					ID       string `json:"id"`
+					Metadata struct {
+						UID string `json:"uid"`
+					} `json:"metadata"`
+				}
+				if err := json.Unmarshal(el, &got); err != nil {
+					t.Fatal(err)
+				}
+
+				uid := got.Metadata.UID
+				if tc.cloudEvents {
+					uid = got.ID
+				}
+				if uid != string(events[i].UID) {
+					t.Errorf("item %d: got uid %q, expected %q", i, uid, events[i].UID)
+				}
+			}
+		})
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		flushed [][]corev1.Event
+	)
+
+	all := newBatchers(func(_ string, _ v1alpha1.RegistrationSpec, events []corev1.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		flushed = append(flushed, events)
+	})
+
+	spec := v1alpha1.RegistrationSpec{
+		Endpoint: "http://127.0.0.1:9090/handle",
+		Batch: &v1alpha1.BatchPolicy{
+			MaxSize:   2,
+			MaxLinger: &metav1.Duration{Duration: 50 * time.Millisecond},
+		},
+	}
+
+	// the first two events are flushed as soon as the batch is full
+	all.add("foo", spec, corev1.Event{Reason: "1"})
+	all.add("foo", spec, corev1.Event{Reason: "2"})
+	// the third one once the linger time is over
+	all.add("foo", spec, corev1.Event{Reason: "3"})
+
+	mu.Lock()
+	if len(flushed) != 1 || len(flushed[0]) != 2 {
+		t.Fatalf("expected one full batch, got %v", flushed)
+	}
+	mu.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(flushed) != 2 || len(flushed[1]) != 1 {
+		t.Fatalf("expected the lingering batch, got %v", flushed)
+	}
+}
+
+func TestBatchersPruneAndStop(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		flushed = map[string]int{}
+		dropped = map[string]int{}
+	)
+
+	all := newBatchers(func(name string, _ v1alpha1.RegistrationSpec, events []corev1.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		flushed[name] += len(events)
+	})
+
+	spec := v1alpha1.RegistrationSpec{
+		Endpoint: "http://127.0.0.1:9090/handle",
+		Batch: &v1alpha1.BatchPolicy{
+			MaxSize:   10,
+			MaxLinger: &metav1.Duration{Duration: time.Hour},
+		},
+	}
+
+	all.add("foo", spec, corev1.Event{Reason: "1"})
+	all.add("bar", spec, corev1.Event{Reason: "2"})
+	all.add("bar", spec, corev1.Event{Reason: "3"})
+
+	// bar has been deleted
+	all.prune(map[string]registration{"foo": {}}, func(name string, _ v1alpha1.RegistrationSpec, events []corev1.Event) {
+		dropped[name] += len(events)
+	})
+	if len(all.items) != 1 || dropped["bar"] != 2 {
+		t.Fatalf("expected the bar batch to be dropped, got %v", dropped)
+	}
+
+	all.stop()
+	// not batched once stopped
+	all.add("foo", spec, corev1.Event{Reason: "4"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(flushed) != 1 || flushed["foo"] != 2 {
+		t.Errorf("flushed: got %v, expected the 2 foo events", flushed)
+	}
+}
+
+func TestBatchersPruneWhileAdding(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		flushed int
+		dropped int
+	)
+
+	all := newBatchers(func(_ string, _ v1alpha1.RegistrationSpec, events []corev1.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		flushed += len(events)
+	})
+
+	spec := v1alpha1.RegistrationSpec{
+		Endpoint: "http://127.0.0.1:9090/handle",
+		Batch: &v1alpha1.BatchPolicy{
+			MaxSize:   1000,
+			MaxLinger: &metav1.Duration{Duration: time.Hour},
+		},
+	}
+
+	const adders, count = 8, 2000
+
+	var wg sync.WaitGroup
+	for i := 0; i < adders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				all.add("foo", spec, corev1.Event{Reason: "LoremIpsum"})
+			}
+		}()
+	}
+
+	stop := make(chan struct{})
+	pruned := make(chan struct{})
+	go func() {
+		defer close(pruned)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			all.prune(map[string]registration{}, func(_ string, _ v1alpha1.RegistrationSpec, events []corev1.Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				dropped += len(events)
+			})
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	<-pruned
+	all.stop()
+
+	// no event is left in an untracked batcher
+	mu.Lock()
+	defer mu.Unlock()
+	if got := flushed + dropped; got != adders*count {
+		t.Errorf("got %d events flushed or dropped, expected %d", got, adders*count)
+	}
+}
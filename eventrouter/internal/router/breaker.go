@@ -0,0 +1,139 @@
+package router
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 30 * time.Second
+)
+
+var errCircuitOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops the deliveries to an endpoint after
+// threshold consecutive failures; once openDuration has elapsed
+// the endpoint is probed, and the circuit is closed only if the
+// probe succeeds. Without a probe the next notification is let
+// through as probe, while the others are still rejected.
+type circuitBreaker struct {
+	threshold    int
+	openDuration time.Duration
+	probe        func() error
+	onOpen       func()
+	now          func() time.Time
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(policy *v1alpha1.CircuitBreakerPolicy, probe func() error, onOpen func()) *circuitBreaker {
+	res := &circuitBreaker{
+		onOpen: onOpen,
+		now:    time.Now,
+	}
+	res.configure(policy, probe)
+
+	return res
+}
+
+// configure applies the policy thresholds and the probe, keeping
+// the circuit state: an open circuit stays open.
+func (b *circuitBreaker) configure(policy *v1alpha1.CircuitBreakerPolicy, probe func() error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.threshold = defaultFailureThreshold
+	b.openDuration = defaultOpenDuration
+	b.probe = probe
+
+	if policy != nil {
+		if policy.FailureThreshold > 0 {
+			b.threshold = int(policy.FailureThreshold)
+		}
+		if policy.OpenDuration != nil && policy.OpenDuration.Duration > 0 {
+			b.openDuration = policy.OpenDuration.Duration
+		}
+	}
+}
+
+// allow tells whether a notification can be delivered.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		return false
+	}
+
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+
+	probe := b.probe
+	if probe == nil {
+		b.state = breakerHalfOpen
+		return true
+	}
+
+	b.probing = true
+	b.mu.Unlock()
+	err := probe()
+	b.mu.Lock()
+	b.probing = false
+
+	if err != nil {
+		b.tripLocked()
+		return false
+	}
+
+	b.state, b.failures = breakerClosed, 0
+	return true
+}
+
+// record reports the outcome of a delivered notification.
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+
+	switch b.state {
+	case breakerHalfOpen:
+		b.tripLocked()
+	case breakerClosed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.tripLocked()
+		}
+	}
+}
+
+func (b *circuitBreaker) tripLocked() {
+	b.state = breakerOpen
+	b.openUntil = b.now().Add(b.openDuration)
+	if b.onOpen != nil {
+		b.onOpen()
+	}
+}
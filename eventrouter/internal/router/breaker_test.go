@@ -0,0 +1,95 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+
+	var probeErr error
+	probes, opened := 0, 0
+
+	policy := &v1alpha1.CircuitBreakerPolicy{
+		FailureThreshold: 2,
+		OpenDuration:     &metav1.Duration{Duration: time.Minute},
+	}
+
+	b := newCircuitBreaker(policy, func() error {
+		probes++
+		return probeErr
+	}, func() { opened++ })
+	b.now = func() time.Time { return now }
+
+	b.record(false)
+	if !b.allow() {
+		t.Fatal("expected closed circuit after one failure")
+	}
+	b.record(false)
+	if b.allow() {
+		t.Fatal("expected open circuit after two failures")
+	}
+	if opened != 1 {
+		t.Errorf("opened: got %d, expected 1", opened)
+	}
+
+	// the probe fails: the circuit stays open
+	now = now.Add(time.Minute)
+	probeErr = errors.New("connection refused")
+	if b.allow() {
+		t.Fatal("expected open circuit after a failed probe")
+	}
+	if probes != 1 || opened != 2 {
+		t.Errorf("probes/opened: got %d/%d, expected 1/2", probes, opened)
+	}
+
+	// the probe succeeds: the circuit is closed
+	now = now.Add(time.Minute)
+	probeErr = nil
+	if !b.allow() {
+		t.Fatal("expected closed circuit after a successful probe")
+	}
+	if !b.allow() || probes != 2 {
+		t.Errorf("probes: got %d, expected 2", probes)
+	}
+}
+
+func TestCircuitBreakerWithoutProbe(t *testing.T) {
+	now := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+
+	b := newCircuitBreaker(&v1alpha1.CircuitBreakerPolicy{FailureThreshold: 1}, nil, nil)
+	b.now = func() time.Time { return now }
+
+	b.record(false)
+	if b.allow() {
+		t.Fatal("expected open circuit")
+	}
+
+	// the next notification is the probe, the others are rejected
+	now = now.Add(defaultOpenDuration)
+	if !b.allow() {
+		t.Fatal("expected the probe notification to be allowed")
+	}
+	if b.allow() {
+		t.Fatal("expected the notifications to be rejected while probing")
+	}
+
+	b.record(false)
+	if b.allow() {
+		t.Fatal("expected open circuit after a failed probe notification")
+	}
+
+	now = now.Add(defaultOpenDuration)
+	if !b.allow() {
+		t.Fatal("expected the probe notification to be allowed")
+	}
+	b.record(true)
+	if !b.allow() {
+		t.Fatal("expected closed circuit after a delivered probe notification")
+	}
+}
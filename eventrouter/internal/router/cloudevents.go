@@ -0,0 +1,123 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsTypePrefix  = "io.krateo.event"
+	cloudEventsContentType = "application/cloudevents+json"
+	jsonContentType        = "application/json"
+)
+
+// cloudEvent is the structured content mode representation
+// of a CloudEvents 1.0 event.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// newCloudEvent maps the event attributes to the CloudEvents context attributes:
+//   - type from the event reason
+//   - source from the involved object
+//   - subject from the composition identifier
+//   - id from the event UID
+func newCloudEvent(evt *corev1.Event, compositionId string) cloudEvent {
+	typ := cloudEventsTypePrefix
+	if len(evt.Reason) > 0 {
+		typ = typ + "." + evt.Reason
+	}
+
+	res := cloudEvent{
+		SpecVersion: cloudEventsSpecVersion,
+		ID:          string(evt.UID),
+		Source:      cloudEventSource(&evt.InvolvedObject),
+		Type:        typ,
+		Subject:     compositionId,
+	}
+
+	if ts := eventTimestamp(evt); !ts.IsZero() {
+		res.Time = ts.UTC().Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// cloudEventSource builds a URI-reference identifying the involved object.
+func cloudEventSource(ref *corev1.ObjectReference) string {
+	prefix := "/apis"
+	if ref.GroupVersionKind().Group == "" {
+		prefix = "/api"
+	}
+
+	parts := []string{prefix, ref.APIVersion}
+	if len(ref.Namespace) > 0 {
+		parts = append(parts, "namespaces", ref.Namespace)
+	}
+	parts = append(parts, ref.Kind, ref.Name)
+
+	return path.Join(parts...)
+}
+
+// encodeNotification returns the body and the headers of the
+// notification according to the requested payload format.
+func encodeNotification(format v1alpha1.PayloadFormat, evt *corev1.Event, compositionId string) ([]byte, http.Header, error) {
+	dat, err := json.Marshal(evt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hdr := http.Header{}
+
+	switch format {
+	case v1alpha1.PayloadFormatCloudEventsBinary:
+		ce := newCloudEvent(evt, compositionId)
+		hdr.Set("Content-Type", jsonContentType)
+		hdr.Set("ce-specversion", ce.SpecVersion)
+		hdr.Set("ce-id", ce.ID)
+		hdr.Set("ce-source", ce.Source)
+		hdr.Set("ce-type", ce.Type)
+		if len(ce.Subject) > 0 {
+			hdr.Set("ce-subject", ce.Subject)
+		}
+		if len(ce.Time) > 0 {
+			hdr.Set("ce-time", ce.Time)
+		}
+		return dat, hdr, nil
+
+	case v1alpha1.PayloadFormatCloudEventsStructured:
+		dat, err = encodeStructured(evt, compositionId, dat)
+		if err != nil {
+			return nil, nil, err
+		}
+		hdr.Set("Content-Type", cloudEventsContentType)
+		return dat, hdr, nil
+
+	default:
+		hdr.Set("Content-Type", jsonContentType)
+		return dat, hdr, nil
+	}
+}
+
+// encodeStructured wraps the JSON encoded event in a
+// structured content mode CloudEvent.
+func encodeStructured(evt *corev1.Event, compositionId string, data []byte) ([]byte, error) {
+	ce := newCloudEvent(evt, compositionId)
+	ce.DataContentType = jsonContentType
+	ce.Data = data
+
+	return json.Marshal(ce)
+}
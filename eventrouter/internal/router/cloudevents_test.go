@@ -0,0 +1,107 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEncodeNotification(t *testing.T) {
+	ts := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+
+	evt := corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "fake-event-1",
+			Namespace: "demo-system",
+			UID:       "90e8c2fe-a54f-4e74-962d-8e6abbce196d",
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Namespace:  "demo-system",
+			Name:       "fake-service-1",
+		},
+		Reason:        "LoremIpsum",
+		LastTimestamp: metav1.Time{Time: ts},
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		dat, hdr, err := encodeNotification("", &evt, "abcde12345")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := hdr.Get("Content-Type"); got != jsonContentType {
+			t.Errorf("content type: got %q, expected %q", got, jsonContentType)
+		}
+
+		var got corev1.Event
+		if err := json.Unmarshal(dat, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.UID != evt.UID {
+			t.Errorf("uid: got %q, expected %q", got.UID, evt.UID)
+		}
+	})
+
+	t.Run("CloudEvents binary", func(t *testing.T) {
+		_, hdr, err := encodeNotification(v1alpha1.PayloadFormatCloudEventsBinary, &evt, "abcde12345")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := map[string]string{
+			"Content-Type":   jsonContentType,
+			"ce-specversion": "1.0",
+			"ce-id":          "90e8c2fe-a54f-4e74-962d-8e6abbce196d",
+			"ce-source":      "/api/v1/namespaces/demo-system/Service/fake-service-1",
+			"ce-type":        "io.krateo.event.LoremIpsum",
+			"ce-subject":     "abcde12345",
+			"ce-time":        "2024-07-05T07:33:09Z",
+		}
+		for k, v := range exp {
+			if got := hdr.Get(k); got != v {
+				t.Errorf("%s: got %q, expected %q", k, got, v)
+			}
+		}
+	})
+
+	t.Run("CloudEvents structured", func(t *testing.T) {
+		dat, hdr, err := encodeNotification(v1alpha1.PayloadFormatCloudEventsStructured, &evt, "abcde12345")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := hdr.Get("Content-Type"); got != cloudEventsContentType {
+			t.Errorf("content type: got %q, expected %q", got, cloudEventsContentType)
+		}
+
+		var ce cloudEvent
+		if err := json.Unmarshal(dat, &ce); err != nil {
+			t.Fatal(err)
+		}
+		if ce.Subject != "abcde12345" {
+			t.Errorf("subject: got %q, expected %q", ce.Subject, "abcde12345")
+		}
+		if len(ce.Data) == 0 {
+			t.Error("data: expected the encoded event")
+		}
+	})
+}
+
+func TestCloudEventSource(t *testing.T) {
+	got := cloudEventSource(&corev1.ObjectReference{
+		APIVersion: "composition.krateo.io/v1-2-0",
+		Kind:       "FireworksApp",
+		Name:       "demo",
+	})
+
+	exp := "/apis/composition.krateo.io/v1-2-0/FireworksApp/demo"
+	if got != exp {
+		t.Errorf("got %q, expected %q", got, exp)
+	}
+}
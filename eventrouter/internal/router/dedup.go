@@ -0,0 +1,121 @@
+package router
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annotationSuppressed is set on the summary events with the
+	// number of occurrences suppressed since the last notification.
+	annotationSuppressed = "krateo.io/suppressed-count"
+)
+
+type dedupEntry struct {
+	// since is the start of the entry window, i.e. when
+	// its first occurrence or its last summary was seen
+	since      time.Time
+	suppressed int
+	last       *corev1.Event
+}
+
+// deduper suppresses the repeated occurrences of an event: the first
+// occurrence is forwarded while the following ones are only counted
+// and, at the end of the window, summarized by a single event.
+type deduper struct {
+	window time.Duration
+	emit   func(evt *corev1.Event)
+	now    func() time.Time
+
+	mu    sync.Mutex
+	items map[string]*dedupEntry
+}
+
+func newDeduper(window time.Duration, emit func(evt *corev1.Event)) *deduper {
+	return &deduper{
+		window: window,
+		emit:   emit,
+		now:    time.Now,
+		items:  map[string]*dedupEntry{},
+	}
+}
+
+// flushInterval is how often flush has to be called: the
+// windows end at most a tenth of their length late.
+func (d *deduper) flushInterval() time.Duration {
+	return max(d.window/10, 10*time.Millisecond)
+}
+
+// dedupKey identifies the occurrences of the same event by
+// involved object, reason and message.
+func dedupKey(evt *corev1.Event) string {
+	ref := &evt.InvolvedObject
+
+	obj := string(ref.UID)
+	if len(obj) == 0 {
+		obj = ref.Namespace + "/" + ref.Kind + "/" + ref.Name
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(evt.Message))
+
+	return obj + "/" + evt.Reason + "/" + strconv.FormatUint(h.Sum64(), 16)
+}
+
+// admit tells whether the event has to be forwarded.
+func (d *deduper) admit(evt *corev1.Event) bool {
+	key := dedupKey(evt)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	el, ok := d.items[key]
+	if !ok {
+		d.items[key] = &dedupEntry{since: d.now()}
+		return true
+	}
+
+	el.suppressed++
+	el.last = evt.DeepCopy()
+	return false
+}
+
+// flush emits a summary for each event whose window has ended with
+// suppressed occurrences, starting a new window; the events without
+// any are forgotten, so that their next occurrence is forwarded again.
+func (d *deduper) flush() {
+	now := d.now()
+
+	d.mu.Lock()
+	var summaries []*corev1.Event
+	for key, el := range d.items {
+		if now.Sub(el.since) < d.window {
+			continue
+		}
+
+		if el.suppressed == 0 {
+			delete(d.items, key)
+			continue
+		}
+
+		evt := el.last
+		annotations := evt.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[annotationSuppressed] = strconv.Itoa(el.suppressed)
+		evt.SetAnnotations(annotations)
+		summaries = append(summaries, evt)
+
+		el.since, el.suppressed, el.last = now, 0, nil
+	}
+	d.mu.Unlock()
+
+	for _, evt := range summaries {
+		d.emit(evt)
+	}
+}
@@ -0,0 +1,112 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeduper(t *testing.T) {
+	now := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+
+	var emitted []*corev1.Event
+	d := newDeduper(time.Minute, func(evt *corev1.Event) {
+		emitted = append(emitted, evt)
+	})
+	d.now = func() time.Time { return now }
+
+	backOff := corev1.Event{
+		InvolvedObject: corev1.ObjectReference{UID: "uid-pod"},
+		Reason:         "BackOff",
+		Message:        "Back-off restarting failed container",
+	}
+	pulled := backOff
+	pulled.Reason = "Pulled"
+
+	admitted := 0
+	for i := 0; i < 5; i++ {
+		evt := backOff
+		evt.Count = int32(i + 1)
+		if d.admit(&evt) {
+			admitted++
+		}
+	}
+	if d.admit(&pulled) {
+		admitted++
+	}
+
+	if admitted != 2 {
+		t.Fatalf("admitted: got %d, expected 2", admitted)
+	}
+
+	// the window has not ended yet
+	now = now.Add(59 * time.Second)
+	d.flush()
+	if len(emitted) != 0 {
+		t.Fatalf("summaries: got %d before the end of the window", len(emitted))
+	}
+
+	now = now.Add(time.Second)
+	d.flush()
+
+	if len(emitted) != 1 {
+		t.Fatalf("summaries: got %d, expected 1", len(emitted))
+	}
+	if got := emitted[0].Annotations[annotationSuppressed]; got != "4" {
+		t.Errorf("suppressed: got %q, expected %q", got, "4")
+	}
+	if emitted[0].Count != 5 {
+		t.Errorf("count: got %d, expected the latest occurrence", emitted[0].Count)
+	}
+
+	// still repeating: suppressed until a window without occurrences
+	if d.admit(&backOff) {
+		t.Error("expected the occurrence to be suppressed")
+	}
+
+	now = now.Add(time.Minute)
+	d.flush()
+	now = now.Add(time.Minute)
+	d.flush()
+
+	if !d.admit(&backOff) {
+		t.Error("expected the occurrence to be admitted after a quiet window")
+	}
+}
+
+func TestDeduperSummaryWatermark(t *testing.T) {
+	store := &memWatermark{}
+	var got handledEvents
+	er := NewEventRouter(EventRouterOpts{
+		Handler:     &got,
+		DedupWindow: time.Minute,
+		Watermark:   store,
+	})
+
+	now := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+	er.dedup.now = func() time.Time { return now }
+
+	evt := &corev1.Event{
+		InvolvedObject: corev1.ObjectReference{UID: "uid-pod"},
+		Reason:         "BackOff",
+		LastTimestamp:  metav1.NewTime(now),
+	}
+	er.onEvent(evt, false)
+
+	repeated := evt.DeepCopy()
+	repeated.LastTimestamp = metav1.NewTime(now.Add(30 * time.Second))
+	er.onEvent(repeated, false)
+
+	now = now.Add(time.Minute)
+	er.dedup.flush()
+	er.replay.save()
+
+	if len(got) != 2 {
+		t.Fatalf("handled: got %d, expected the event and its summary", len(got))
+	}
+	if !store.t.Equal(repeated.LastTimestamp.Time) {
+		t.Errorf("watermark: got %v, expected the summary time %v", store.t, repeated.LastTimestamp.Time)
+	}
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	coreAPIGroup = "core"
+)
+
+// eventFilter is the compiled form of a v1alpha1.RegistrationFilter.
+// A nil eventFilter matches every event.
+type eventFilter struct {
+	apiGroups  sets.Set[string]
+	kinds      sets.Set[string]
+	namespaces sets.Set[string]
+	types      sets.Set[string]
+	reasons    []*regexp.Regexp
+	selector   labels.Selector
+}
+
+func newEventFilter(spec *v1alpha1.RegistrationFilter) (*eventFilter, error) {
+	if spec == nil {
+		return nil, nil
+	}
+
+	res := &eventFilter{
+		apiGroups:  sets.New[string](),
+		kinds:      sets.New(spec.Kinds...),
+		namespaces: sets.New(spec.Namespaces...),
+		types:      sets.New(spec.Types...),
+	}
+
+	for _, el := range spec.APIGroups {
+		if el == coreAPIGroup {
+			el = ""
+		}
+		res.apiGroups.Insert(el)
+	}
+
+	for _, el := range spec.Reasons {
+		rx, err := regexp.Compile(el)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reason expression %q: %w", el, err)
+		}
+		res.reasons = append(res.reasons, rx)
+	}
+
+	if spec.Selector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector: %w", err)
+		}
+		res.selector = sel
+	}
+
+	return res, nil
+}
+
+// Match reports whether the event (and the labels of its resolved
+// involved object) satisfies all the filter criteria.
+func (f *eventFilter) Match(evt *corev1.Event, objLabels map[string]string) bool {
+	return f.MatchEvent(evt) && f.MatchLabels(objLabels)
+}
+
+// MatchEvent reports whether the event satisfies the filter
+// criteria not depending on the involved object.
+func (f *eventFilter) MatchEvent(evt *corev1.Event) bool {
+	if f == nil {
+		return true
+	}
+
+	ref := &evt.InvolvedObject
+
+	if f.apiGroups.Len() > 0 && !f.apiGroups.Has(ref.GroupVersionKind().Group) {
+		return false
+	}
+
+	if f.kinds.Len() > 0 && !f.kinds.Has(ref.Kind) {
+		return false
+	}
+
+	if f.namespaces.Len() > 0 && !f.namespaces.Has(ref.Namespace) {
+		return false
+	}
+
+	if f.types.Len() > 0 && !f.types.Has(evt.Type) {
+		return false
+	}
+
+	if len(f.reasons) > 0 {
+		found := false
+		for _, rx := range f.reasons {
+			if rx.MatchString(evt.Reason) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchLabels reports whether the labels of the involved
+// object satisfy the filter label selector.
+func (f *eventFilter) MatchLabels(objLabels map[string]string) bool {
+	return !f.selectsLabels() || f.selector.Matches(labels.Set(objLabels))
+}
+
+// selectsLabels tells whether the filter needs the
+// labels of the involved object.
+func (f *eventFilter) selectsLabels() bool {
+	return f != nil && f.selector != nil
+}
@@ -0,0 +1,128 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventFilterMatch(t *testing.T) {
+	evt := corev1.Event{
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Namespace:  "demo-system",
+			Name:       "nginx",
+		},
+		Type:   corev1.EventTypeWarning,
+		Reason: "FailedCreate",
+	}
+
+	objLabels := map[string]string{
+		"app": "nginx",
+	}
+
+	tests := []struct {
+		name     string
+		spec     *v1alpha1.RegistrationFilter
+		expected bool
+	}{
+		{
+			name:     "No filter",
+			spec:     nil,
+			expected: true,
+		},
+		{
+			name:     "Empty filter",
+			spec:     &v1alpha1.RegistrationFilter{},
+			expected: true,
+		},
+		{
+			name: "Matching apiGroup and kind",
+			spec: &v1alpha1.RegistrationFilter{
+				APIGroups: []string{"apps"},
+				Kinds:     []string{"Deployment", "StatefulSet"},
+			},
+			expected: true,
+		},
+		{
+			name: "Core apiGroup",
+			spec: &v1alpha1.RegistrationFilter{
+				APIGroups: []string{"core"},
+			},
+			expected: false,
+		},
+		{
+			name: "Other namespace",
+			spec: &v1alpha1.RegistrationFilter{
+				Namespaces: []string{"kube-system"},
+			},
+			expected: false,
+		},
+		{
+			name: "Normal events only",
+			spec: &v1alpha1.RegistrationFilter{
+				Types: []string{corev1.EventTypeNormal},
+			},
+			expected: false,
+		},
+		{
+			name: "Matching reason expression",
+			spec: &v1alpha1.RegistrationFilter{
+				Reasons: []string{"^Scaling", "^Failed.*"},
+			},
+			expected: true,
+		},
+		{
+			name: "Not matching reason expression",
+			spec: &v1alpha1.RegistrationFilter{
+				Reasons: []string{"^Scaling"},
+			},
+			expected: false,
+		},
+		{
+			name: "Matching label selector",
+			spec: &v1alpha1.RegistrationFilter{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "nginx"},
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "Not matching label selector",
+			spec: &v1alpha1.RegistrationFilter{
+				Selector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "tier", Operator: metav1.LabelSelectorOpExists},
+					},
+				},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newEventFilter(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := f.Match(&evt, objLabels); got != tt.expected {
+				t.Errorf("Match() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEventFilterInvalidReason(t *testing.T) {
+	_, err := newEventFilter(&v1alpha1.RegistrationFilter{
+		Reasons: []string{"("},
+	})
+	if err == nil {
+		t.Fatal("expected error compiling invalid reason expression")
+	}
+}
@@ -1,61 +1,210 @@
 package router
 
 import (
-	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"github.com/krateoplatformops/eventrouter/internal/deadletter"
+	"github.com/krateoplatformops/eventrouter/internal/health"
 	httpHelper "github.com/krateoplatformops/eventrouter/internal/helpers/http"
 	"github.com/krateoplatformops/eventrouter/internal/helpers/queue"
 	"github.com/krateoplatformops/eventrouter/internal/objects"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 )
 
 type PusherOpts struct {
-	RESTConfig *rest.Config
-	Queue      queue.Queuer
-	Verbose    bool
-	Insecure   bool
+	RESTConfig    *rest.Config
+	Registrations *RegistrationCache
+	Queue         queue.Queuer
+	Verbose       bool
+	Insecure      bool
+	// DeadLetters stores the notifications that exhausted their retries (optional).
+	DeadLetters deadletter.Store
+	// DeadLetterReplayInterval is how often the dead letters are
+	// re-queued; zero disables the replay.
+	DeadLetterReplayInterval time.Duration
+	// DeadLetterMaxAttempts is how many delivery attempts, replays
+	// included, a dead letter is no longer replayed after; zero
+	// means no limit.
+	DeadLetterMaxAttempts int
+	// OwnerMaxDepth is how many ownerReferences levels are followed
+	// looking for the composition identifier; zero disables the lookup.
+	OwnerMaxDepth int
+	// Enrichment configures the involved object metadata copied onto the events.
+	Enrichment Enrichment
+	// CompositionCacheSize is the maximum number of cached
+	// composition identifiers; zero disables the cache.
+	CompositionCacheSize int
+	// CompositionCacheTTL is how long a composition identifier is cached.
+	CompositionCacheTTL time.Duration
+	// LaneCapacity is how many notifications each Registration
+	// lane can hold, besides the ones being delivered (default: 100).
+	LaneCapacity int
+	// SecretNamespaces are the namespaces of the Secrets the
+	// Registrations can reference (AnyNamespace allows them all);
+	// the other Secrets are not read and their notifications fail.
+	SecretNamespaces []string
+	// StatusUpdateInterval is how often the Registrations status is
+	// patched with the delivery stats; zero disables the updates.
+	StatusUpdateInterval time.Duration
 }
 
-func NewPusher(opts PusherOpts) (EventHandler, error) {
+// Pusher is the EventHandler notifying the Registrations.
+type Pusher interface {
+	EventHandler
+	// Start restores the pending notifications and starts the dead
+	// letters replay and the Registrations status updates, until
+	// stopCh is closed; only the leader starts them.
+	Start(stopCh <-chan struct{}) error
+	// Lanes returns the delivery lanes of the Registrations,
+	// which report their progress to the liveness check.
+	Lanes() map[string]health.Dispatcher
+	// Stop flushes the pending batches, drains and terminates the
+	// notification queue and stops the lanes, once the events are
+	// no longer handled: the failed notifications waiting to be
+	// retried are stored as dead letters.
+	Stop()
+}
+
+func NewPusher(opts PusherOpts) (Pusher, error) {
 	objectResolver, err := objects.NewObjectResolver(opts.RESTConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	return &pusher{
-		objectResolver: objectResolver,
-		notifyQueue:    opts.Queue,
-		verbose:        opts.Verbose,
+	compositions := newCompositionResolver(objectResolver, opts.Enrichment,
+		opts.OwnerMaxDepth, opts.CompositionCacheSize, opts.CompositionCacheTTL)
+
+	res := &pusher{
+		objectResolver:        objectResolver,
+		compositions:          compositions,
+		compositionIdKey:      opts.Enrichment.compositionIDKey(),
+		registrations:         opts.Registrations,
+		notifyQueue:           opts.Queue,
+		deadLetters:           opts.DeadLetters,
+		deadLetterMaxAttempts: opts.DeadLetterMaxAttempts,
+		replayInterval:        opts.DeadLetterReplayInterval,
+		statusInterval:        opts.StatusUpdateInterval,
+		stats:                 newDeliveryStats(),
+		auth:                  newAuthenticator(objectResolver, opts.Verbose, opts.Insecure, opts.SecretNamespaces),
+		verbose:               opts.Verbose,
 		httpClient: httpHelper.ClientFromOpts(httpHelper.ClientOpts{
 			Verbose:  opts.Verbose,
 			Insecure: opts.Insecure,
 		}),
-	}, nil
+	}
+	res.batchers = newBatchers(res.push)
+	res.lanes = newLanes(opts.LaneCapacity, opts.Registrations, res.httpClient)
+	opts.Registrations.onRemove(res.removed)
+
+	return res, nil
 }
 
-var _ EventHandler = (*pusher)(nil)
+var _ Pusher = (*pusher)(nil)
 
 type pusher struct {
-	objectResolver *objects.ObjectResolver
-	notifyQueue    queue.Queuer
-	httpClient     *http.Client
-	verbose        bool
+	objectResolver        *objects.ObjectResolver
+	compositions          *compositionResolver
+	compositionIdKey      string
+	registrations         *RegistrationCache
+	notifyQueue           queue.Queuer
+	deadLetters           deadletter.Store
+	deadLetterMaxAttempts int
+	replayInterval        time.Duration
+	statusInterval        time.Duration
+	stats                 *deliveryStats
+	auth                  *authenticator
+	batchers              *batchers
+	lanes                 *lanes
+	httpClient            *http.Client
+	verbose               bool
+}
+
+func (c *pusher) Start(stopCh <-chan struct{}) error {
+	if q, ok := c.notifyQueue.(queue.Restorer); ok {
+		tot, err := q.Restore(c.restore)
+		if err != nil {
+			return fmt.Errorf("cannot restore the pending notifications: %w", err)
+		}
+		if tot > 0 {
+			klog.InfoS("pending notifications restored", "count", tot)
+		}
+	}
+
+	if c.deadLetters != nil && c.replayInterval > 0 {
+		go wait.Until(c.replayDeadLetters, c.replayInterval, stopCh)
+	}
+
+	if c.statusInterval > 0 {
+		su := &statusUpdater{
+			resolver:      c.objectResolver,
+			registrations: c.registrations,
+			stats:         c.stats,
+			written:       map[string]v1alpha1.RegistrationStatus{},
+		}
+		go wait.Until(su.update, c.statusInterval, stopCh)
+	}
+
+	return nil
+}
+
+func (c *pusher) Lanes() map[string]health.Dispatcher {
+	return c.lanes.dispatchers()
+}
+
+func (c *pusher) Stop() {
+	c.batchers.stop()
+	c.notifyQueue.Terminate()
+	c.lanes.stop()
 }
 
 func (c *pusher) Handle(evt corev1.Event) {
 	ref := &evt.InvolvedObject
 
-	compositionId, err := findCompositionID(c.objectResolver, ref)
+	all := c.registrations.all()
+	if len(all) == 0 {
+		klog.V(4).InfoS("no registrations found", "involvedObject", ref.Name)
+		return
+	}
+
+	// the involved object is resolved only if some
+	// Registration may still be interested in the event
+	candidates := map[string]registration{}
+	for name, el := range all {
+		if el.filter.MatchEvent(&evt) {
+			candidates[name] = el
+			continue
+		}
+		filtered(name, &evt)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	start := time.Now()
+	obj, err := c.compositions.find(ref)
+	compositionIdDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		klog.ErrorS(err, "looking for composition id", "involvedObject", ref.Name)
-		return
+
+		// like an object not found, without composition id; the
+		// Registrations selecting the object labels are skipped
+		obj = resolvedObject{}
+		for name, el := range candidates {
+			if el.filter.selectsLabels() {
+				delete(candidates, name)
+			}
+		}
+		if len(candidates) == 0 {
+			return
+		}
 	}
 
 	klog.V(4).InfoS(evt.Message,
@@ -63,13 +212,7 @@ func (c *pusher) Handle(evt corev1.Event) {
 		"kind", ref.Kind,
 		"apiGroup", evt.InvolvedObject.GroupVersionKind().Group,
 		"reason", evt.Reason,
-		"compositionId", compositionId)
-
-	all, err := c.getAllRegistrations(context.Background())
-	if err != nil {
-		klog.ErrorS(err, "unable to list registrations", "involvedObject", ref.Name)
-		return
-	}
+		"compositionId", obj.cid)
 
 	if len(evt.ManagedFields) == 0 {
 		evt.ManagedFields = nil
@@ -77,65 +220,106 @@ func (c *pusher) Handle(evt corev1.Event) {
 
 	labels := evt.GetLabels()
 	if labels == nil {
-		labels = map[string]string{
-			keyCompositionID: compositionId,
-		}
-	} else {
-		labels[keyCompositionID] = compositionId
+		labels = map[string]string{}
+	}
+	for k, v := range obj.enrichLabels {
+		labels[k] = v
 	}
+	labels[c.compositionIdKey] = obj.cid
 	evt.SetLabels(labels)
 
-	c.notifyAll(all, evt)
+	if len(obj.enrichAnnotations) > 0 {
+		annotations := evt.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		for k, v := range obj.enrichAnnotations {
+			annotations[k] = v
+		}
+		evt.SetAnnotations(annotations)
+	}
+
+	c.notifyAll(candidates, evt, obj.labels)
 }
 
-func (c *pusher) notifyAll(all map[string]v1alpha1.RegistrationSpec, evt corev1.Event) {
-	for _, el := range all {
-		job := newAdvisor(advOpts{
-			httpClient:       c.httpClient,
-			registrationSpec: el,
-			eventInfo:        evt,
-		})
+// notifyAll notifies the event to the Registrations whose
+// filter selects the labels of the involved object.
+func (c *pusher) notifyAll(all map[string]registration, evt corev1.Event, objLabels map[string]string) {
+	for name, el := range all {
+		if !el.filter.MatchLabels(objLabels) {
+			filtered(name, &evt)
+			continue
+		}
+
+		if el.spec.Batch != nil {
+			c.batchers.add(name, el.spec, evt)
+			continue
+		}
 
-		c.notifyQueue.Push(job)
+		c.push(name, el.spec, []corev1.Event{evt})
 	}
 }
 
-func (c *pusher) getAllRegistrations(ctx context.Context) (map[string]v1alpha1.RegistrationSpec, error) {
-	all, err := c.objectResolver.List(ctx, schema.GroupVersionKind{
-		Group:   "eventrouter.krateo.io",
-		Version: "v1alpha1",
-		Kind:    "Registration",
-	}, "")
+// filtered records the event rejected by the Registration filter.
+func filtered(name string, evt *corev1.Event) {
+	eventsFiltered.WithLabelValues(name).Inc()
+	klog.V(4).InfoS("event filtered out",
+		"registration", name,
+		"name", evt.Name,
+		"reason", evt.Reason)
+}
+
+// removed releases the resources of the deleted Registrations;
+// their pending batches are stored as dead letters.
+func (c *pusher) removed(name string) {
+	all := c.registrations.all()
 
-	res := map[string]v1alpha1.RegistrationSpec{}
-	if err != nil {
-		return res, err
-	}
+	c.batchers.prune(all, func(name string, spec v1alpha1.RegistrationSpec, events []corev1.Event) {
+		c.advisor(name, spec, events, 0).reject(errRegistrationNotFound)
+	})
+	c.lanes.prune(all)
+	c.auth.prune(all)
 
-	if all == nil {
-		return res, nil
+	if _, ok := all[name]; !ok {
+		deleteRegistrationMetrics(name)
 	}
+}
 
-	for _, el := range all.Items {
-		serviceName, _, err := unstructured.NestedString(el.Object, "spec", "serviceName")
-		if err != nil {
-			klog.ErrorS(err, "unable to read 'serviceName' attribute",
-				"registration", el.GetName())
-			continue
-		}
+// push queues the notification of the events to the Registration endpoint.
+func (c *pusher) push(name string, spec v1alpha1.RegistrationSpec, events []corev1.Event) {
+	c.notifyQueue.Push(c.advisor(name, spec, events, 0))
+}
 
-		endpoint, _, err := unstructured.NestedString(el.Object, "spec", "endpoint")
-		if err != nil {
-			klog.ErrorS(err, "unable to read 'endpoint' attribute",
-				"registration", el.GetName())
-			continue
-		}
+// advisor returns the notification of the events; attempts is how
+// many delivery attempts have already been made.
+func (c *pusher) advisor(name string, spec v1alpha1.RegistrationSpec, events []corev1.Event, attempts int) *advisor {
+	return newAdvisor(advOpts{
+		httpClient:       c.httpClient,
+		registrationName: name,
+		registrationSpec: spec,
+		events:           events,
+		compositionIdKey: c.compositionIdKey,
+		deadLetters:      c.deadLetters,
+		stats:            c.stats,
+		auth:             c.auth,
+		lanes:            c.lanes,
+		attempts:         attempts,
+	})
+}
 
-		res[el.GetName()] = v1alpha1.RegistrationSpec{
-			ServiceName: serviceName,
-			Endpoint:    endpoint,
-		}
+// restore decodes a notification persisted by the disk queue; its
+// Registration spec is resolved when the notification is delivered.
+func (c *pusher) restore(dat []byte) (queue.Jober, error) {
+	var el storedAdvisor
+	if err := json.Unmarshal(dat, &el); err != nil {
+		return nil, err
+	}
+
+	if len(el.Events) == 0 {
+		return nil, fmt.Errorf("notification for %q without events", el.Registration)
 	}
 
+	res := c.advisor(el.Registration, v1alpha1.RegistrationSpec{}, el.Events, el.Attempts)
+	res.registrations = c.registrations
 	return res, nil
 }
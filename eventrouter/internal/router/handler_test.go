@@ -0,0 +1,240 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"github.com/krateoplatformops/eventrouter/internal/helpers/queue"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type mockQueue struct {
+	mu   sync.Mutex
+	jobs []queue.Jober
+}
+
+func (q *mockQueue) Run()       {}
+func (q *mockQueue) Terminate() {}
+
+func (q *mockQueue) Push(job queue.Jober) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs = append(q.jobs, job)
+}
+
+// notified returns the names of the Registrations notified.
+func (q *mockQueue) notified() map[string]string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	res := map[string]string{}
+	for _, el := range q.jobs {
+		adv := el.(*advisor)
+		res[adv.name] = compositionIdOf(&adv.events[0], adv.compositionIdKey)
+	}
+	q.jobs = nil
+	return res
+}
+
+func TestHandleFilters(t *testing.T) {
+	register := func(name string, spec *v1alpha1.RegistrationFilter) registration {
+		filter, err := newEventFilter(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return registration{name: name, filter: filter}
+	}
+
+	var (
+		gets int
+		err  error
+	)
+	q := &mockQueue{}
+	p := &pusher{
+		compositions: &compositionResolver{
+			get: func(ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
+				gets++
+				if err != nil {
+					return nil, err
+				}
+				obj := &unstructured.Unstructured{}
+				obj.SetLabels(map[string]string{
+					DefaultCompositionIDKey: "abcde12345",
+					"app":                   "nginx",
+				})
+				return obj, nil
+			},
+			objects: newLRUCache[string, resolvedObject](0, time.Minute),
+			owners:  newLRUCache[types.UID, resolvedObject](0, time.Minute),
+		},
+		compositionIdKey: DefaultCompositionIDKey,
+		notifyQueue:      q,
+		registrations: &RegistrationCache{items: map[string]registration{
+			"warnings": register("warnings", &v1alpha1.RegistrationFilter{
+				Types: []string{corev1.EventTypeWarning},
+			}),
+			"nginx": register("nginx", &v1alpha1.RegistrationFilter{
+				Types:    []string{corev1.EventTypeWarning},
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}},
+			}),
+		}},
+	}
+
+	evt := corev1.Event{
+		InvolvedObject: corev1.ObjectReference{Kind: "Deployment", Name: "nginx"},
+		Type:           corev1.EventTypeNormal,
+	}
+
+	// rejected by all the filters: the object is not resolved
+	p.Handle(evt)
+	if gets != 0 {
+		t.Errorf("got %d lookups, expected none", gets)
+	}
+	if got := q.notified(); len(got) != 0 {
+		t.Errorf("notified: got %v, expected none", got)
+	}
+
+	evt.Type = corev1.EventTypeWarning
+	p.Handle(evt)
+	if gets != 1 {
+		t.Errorf("got %d lookups, expected 1", gets)
+	}
+	got := q.notified()
+	if len(got) != 2 || got["warnings"] != "abcde12345" || got["nginx"] != "abcde12345" {
+		t.Errorf("notified: got %v, expected warnings and nginx", got)
+	}
+
+	// a failed lookup skips only the Registrations selecting the object labels
+	err = errors.New("boom")
+	p.Handle(evt)
+	got = q.notified()
+	if _, ok := got["warnings"]; !ok || len(got) != 1 {
+		t.Errorf("notified: got %v, expected warnings only", got)
+	}
+}
+
+func TestPusherStop(t *testing.T) {
+	called := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case called <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	sink := &mockSink{}
+	p := &pusher{
+		notifyQueue: &mockQueue{},
+		lanes:       newLanes(1, nil, srv.Client()),
+	}
+	p.batchers = newBatchers(p.push)
+
+	p.lanes.submit(newAdvisor(advOpts{
+		httpClient:       srv.Client(),
+		registrationName: "test",
+		registrationSpec: v1alpha1.RegistrationSpec{
+			Endpoint: srv.URL,
+			Retry: &v1alpha1.RetryPolicy{
+				MaxAttempts: 5,
+				Backoff:     &metav1.Duration{Duration: time.Hour},
+			},
+		},
+		events:      []corev1.Event{{Reason: "Test"}},
+		deadLetters: sink,
+	}), nil)
+
+	// waiting for the first retry
+	<-called
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the retry backoff to be interrupted")
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.all) != 1 {
+		t.Errorf("expected the dead letter once stopped, got %+v", sink.all)
+	}
+}
+
+func TestPusherStopDrainsQueue(t *testing.T) {
+	var mu sync.Mutex
+	delivered := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		delivered++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	q := queue.NewQueue(10, 1)
+	q.Run()
+
+	p := &pusher{
+		notifyQueue: q,
+		httpClient:  srv.Client(),
+		lanes:       newLanes(1, nil, srv.Client()),
+	}
+	p.batchers = newBatchers(p.push)
+
+	spec := v1alpha1.RegistrationSpec{
+		Endpoint: srv.URL,
+		Batch: &v1alpha1.BatchPolicy{
+			MaxSize:   10,
+			MaxLinger: &metav1.Duration{Duration: time.Hour},
+		},
+	}
+	p.batchers.add("test", spec, corev1.Event{Reason: "Test"})
+
+	p.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if delivered != 1 {
+		t.Errorf("expected the pending batch delivered once stopped, got %d deliveries", delivered)
+	}
+}
+
+func TestPusherRemovedMetrics(t *testing.T) {
+	p := &pusher{
+		registrations: &RegistrationCache{items: map[string]registration{"kept": {name: "kept"}}},
+		lanes:         newLanes(1, nil, http.DefaultClient),
+		auth:          newAuthenticator(nil, false, false, nil),
+	}
+	p.batchers = newBatchers(p.push)
+
+	for _, name := range []string{"kept", "gone"} {
+		eventsDelivered.WithLabelValues(name).Inc()
+		deliveryDuration.WithLabelValues(name).Observe(1)
+	}
+
+	p.removed("gone")
+
+	// nothing left to delete
+	if eventsDelivered.DeleteLabelValues("gone") || deliveryDuration.DeleteLabelValues("gone") {
+		t.Error("expected the removed registration series deleted")
+	}
+	if testutil.ToFloat64(eventsDelivered.WithLabelValues("kept")) != 1 {
+		t.Error("expected the kept registration series untouched")
+	}
+}
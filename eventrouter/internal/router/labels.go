@@ -3,35 +3,94 @@ package router
 import (
 	"context"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/krateoplatformops/eventrouter/internal/objects"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/util/retry"
-	"k8s.io/klog/v2"
 )
 
 const (
-	keyCompositionID = "krateo.io/composition-id"
+	DefaultCompositionIDKey = "krateo.io/composition-id"
 )
 
-func hasCompositionId(obj *corev1.Event) bool {
+// Enrichment configures the involved object metadata
+// copied onto the forwarded events.
+type Enrichment struct {
+	// CompositionIDKey is the label holding the composition
+	// identifier (default: krateo.io/composition-id).
+	CompositionIDKey string
+	// Labels are the label keys copied onto the event labels.
+	Labels []string
+	// Annotations are the annotation keys copied onto the event annotations.
+	Annotations []string
+}
+
+func (e Enrichment) compositionIDKey() string {
+	if len(e.CompositionIDKey) == 0 {
+		return DefaultCompositionIDKey
+	}
+	return e.CompositionIDKey
+}
+
+func hasCompositionId(obj *corev1.Event, key string) bool {
 	labels := obj.GetLabels()
 	if len(labels) == 0 {
 		return false
 	}
 
-	val, ok := labels[keyCompositionID]
+	val, ok := labels[key]
 	if len(val) == 0 {
 		return false
 	}
 	return ok
 }
 
-func findCompositionID(resolver *objects.ObjectResolver, ref *corev1.ObjectReference) (cid string, err error) {
+// compositionIdOf returns the composition identifier
+// the event has been labeled with.
+func compositionIdOf(obj *corev1.Event, key string) string {
+	labels := obj.GetLabels()
+	if len(labels) == 0 {
+		return ""
+	}
+	return labels[key]
+}
+
+// selectKeys returns the entries of the map with the given keys.
+func selectKeys(all map[string]string, keys []string) map[string]string {
+	var res map[string]string
+	for _, k := range keys {
+		val, ok := all[k]
+		if !ok {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string, len(keys))
+		}
+		res[k] = val
+	}
+	return res
+}
+
+// merge adds to dst the entries of src it doesn't already have.
+func merge(dst, src map[string]string) map[string]string {
+	for k, v := range src {
+		if _, ok := dst[k]; ok {
+			continue
+		}
+		if dst == nil {
+			dst = make(map[string]string, len(src))
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// resolveObject fetches the referenced object, invalidating the
+// REST mapper cache on failures; it returns nil if not found.
+func resolveObject(resolver *objects.ObjectResolver, ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
 	var obj *unstructured.Unstructured
 
-	retryErr := retry.OnError(retry.DefaultRetry,
+	err := retry.OnError(retry.DefaultRetry,
 		func(e error) bool {
 			if e != nil {
 				resolver.InvalidateRESTMapperCache()
@@ -39,33 +98,10 @@ func findCompositionID(resolver *objects.ObjectResolver, ref *corev1.ObjectRefer
 			}
 			return false
 		},
-		func() error {
+		func() (err error) {
 			obj, err = resolver.ResolveReference(context.Background(), ref)
 			return err
 		})
-	if retryErr != nil {
-		return "", retryErr
-	}
-
-	if obj == nil {
-		klog.V(4).InfoS("object not found resolving reference",
-			"name", ref.Name,
-			"kind", ref.Kind,
-			"apiVersion", ref.APIVersion)
-		return "", nil
-	}
-
-	labels := obj.GetLabels()
-	if len(labels) == 0 {
-		klog.V(4).InfoS("no labels found in resolved reference",
-			"name", ref.Name,
-			"kind", ref.Kind,
-			"apiVersion", ref.APIVersion)
-		return "", nil
-	}
-
-	klog.V(4).InfoS("labels found in resolved reference",
-		"labels", spew.Sdump(labels))
 
-	return labels[keyCompositionID], nil
+	return obj, err
 }
@@ -0,0 +1,437 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"github.com/krateoplatformops/eventrouter/internal/health"
+	"github.com/krateoplatformops/eventrouter/internal/helpers/queue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultDeliveryTimeout = 40 * time.Second
+	defaultMaxConcurrency  = 4
+	defaultLaneCapacity    = 100
+)
+
+var (
+	errLaneFull    = errors.New("delivery lane full")
+	errLaneStopped = errors.New("delivery lane stopped")
+)
+
+// deliveryTimeout returns the timeout of each delivery attempt.
+func deliveryTimeout(policy *v1alpha1.DeliveryPolicy) time.Duration {
+	if policy != nil && policy.Timeout != nil && policy.Timeout.Duration > 0 {
+		return policy.Timeout.Duration
+	}
+	return defaultDeliveryTimeout
+}
+
+// lane delivers the notifications of a Registration with its own
+// workers and circuit breaker, so that a slow or failing endpoint
+// doesn't hold up the deliveries to the others.
+type lane struct {
+	name       string
+	capacity   int
+	httpClient *http.Client
+	breaker    *circuitBreaker
+
+	// mu guards the queue replacement and termination
+	// against the pushes in progress
+	mu      sync.RWMutex
+	policy  *v1alpha1.DeliveryPolicy
+	workers int
+	queue   *queue.Queue
+	stopped bool
+	// quit is closed once the lane is stopped,
+	// interrupting the retries waiting for a backoff
+	quit chan struct{}
+
+	// bmu guards the backlog, the persisted notifications
+	// waiting for some room in the lane; it's taken before mu
+	bmu     sync.Mutex
+	backlog []*laneJob
+}
+
+func newLane(name string, policy *v1alpha1.DeliveryPolicy, capacity int, httpClient *http.Client) *lane {
+	res := &lane{
+		name:       name,
+		capacity:   capacity,
+		httpClient: httpClient,
+		quit:       make(chan struct{}),
+		breaker: newCircuitBreaker(nil, nil, func() {
+			circuitBreakerOpened.WithLabelValues(name).Inc()
+			klog.InfoS("circuit breaker open", "registration", name)
+		}),
+	}
+	res.update(policy)
+
+	return res
+}
+
+// update applies the delivery policy keeping the circuit breaker
+// state; the queue is replaced only when the concurrency changes,
+// the previous one is drained in background.
+func (l *lane) update(policy *v1alpha1.DeliveryPolicy) {
+	workers := defaultMaxConcurrency
+	if policy != nil && policy.MaxConcurrency > 0 {
+		workers = int(policy.MaxConcurrency)
+	}
+
+	var cb *v1alpha1.CircuitBreakerPolicy
+	if policy != nil {
+		cb = policy.CircuitBreaker
+	}
+
+	var probe func() error
+	if cb != nil && len(cb.HealthEndpoint) > 0 {
+		probe = func() error {
+			return probeEndpoint(l.httpClient, cb.HealthEndpoint, deliveryTimeout(policy))
+		}
+	}
+	l.breaker.configure(cb, probe)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.policy = policy
+	if l.queue != nil && l.workers == workers {
+		return
+	}
+
+	if l.queue != nil {
+		go l.queue.Terminate()
+	}
+
+	// a full lane never holds up the workers pushing the
+	// notifications: push reports it, see lanes.submit
+	l.queue = queue.NewQueueWithOverflow(l.capacity, workers, queue.Overflow{
+		Policy: queue.OverflowDropNewest,
+		OnDrop: func(job queue.Jober) {
+			job.(*laneJob).full = true
+		},
+	})
+	l.workers = workers
+	l.queue.Run()
+
+	// the new queue is empty
+	go l.drain()
+}
+
+// changed tells whether the delivery policy differs from the lane one.
+func (l *lane) changed(policy *v1alpha1.DeliveryPolicy) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return !reflect.DeepEqual(l.policy, policy)
+}
+
+// push queues the job without blocking; it returns errLaneFull if
+// the job doesn't fit in the lane and errLaneStopped if the lane
+// has been stopped.
+func (l *lane) push(job *laneJob) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.stopped {
+		return errLaneStopped
+	}
+
+	job.lane, job.full = l, false
+	l.queue.Push(job)
+	if job.full {
+		return errLaneFull
+	}
+
+	return nil
+}
+
+// offer queues the job without blocking; when the lane is full, the
+// job is kept in the backlog if hold is true, otherwise errLaneFull
+// is returned. The held jobs never overtake the ones in the backlog.
+func (l *lane) offer(job *laneJob, hold bool) error {
+	l.bmu.Lock()
+	defer l.bmu.Unlock()
+
+	if len(l.backlog) == 0 || !hold {
+		err := l.push(job)
+		if err != errLaneFull || !hold {
+			return err
+		}
+	} else if l.isStopped() {
+		return errLaneStopped
+	}
+
+	l.backlog = append(l.backlog, job)
+	return nil
+}
+
+// drain moves the backlog jobs to the lane, as long as they fit.
+func (l *lane) drain() {
+	l.bmu.Lock()
+	defer l.bmu.Unlock()
+
+	for len(l.backlog) > 0 {
+		if l.push(l.backlog[0]) != nil {
+			return
+		}
+		l.backlog[0] = nil
+		l.backlog = l.backlog[1:]
+	}
+}
+
+// GetJobCount returns the number of notifications waiting
+// in the lane, the backlog included.
+func (l *lane) GetJobCount() int {
+	l.bmu.Lock()
+	res := len(l.backlog)
+	l.bmu.Unlock()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return res + l.queue.GetJobCount()
+}
+
+// GetLastDispatch returns the last time a notification
+// was handed to a lane worker.
+func (l *lane) GetLastDispatch() time.Time {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.queue.GetLastDispatch()
+}
+
+func (l *lane) isStopped() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.stopped
+}
+
+// stop waits for the queued notifications and releases the workers;
+// the failed notifications are no longer retried. It returns the
+// jobs left in the backlog, which have not been handled.
+func (l *lane) stop() []*laneJob {
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return nil
+	}
+	l.stopped = true
+	close(l.quit)
+	l.mu.Unlock()
+
+	l.bmu.Lock()
+	backlog := l.backlog
+	l.backlog = nil
+	l.bmu.Unlock()
+
+	l.queue.Terminate()
+
+	return backlog
+}
+
+// run delivers the notification, unless the circuit is open.
+func (l *lane) run(job *advisor) {
+	if !l.breaker.allow() {
+		job.reject(errCircuitOpen)
+		return
+	}
+
+	err := job.deliver(l.quit)
+	l.breaker.record(err == nil || !isRetryable(err))
+}
+
+// laneJob is a notification queued in a lane.
+type laneJob struct {
+	lane *lane
+	adv  *advisor
+	done func()
+	// full is set when the lane rejects the job
+	full bool
+}
+
+func (j *laneJob) Job() {
+	// the job has left the lane queue: its slot is free
+	j.lane.drain()
+	j.lane.run(j.adv)
+	j.finish()
+}
+
+// drop rejects the notification since the lane is full.
+func (j *laneJob) drop() {
+	laneOverflows.WithLabelValues(j.adv.name).Inc()
+	j.adv.reject(errLaneFull)
+	j.finish()
+}
+
+func (j *laneJob) finish() {
+	if j.done != nil {
+		j.done()
+	}
+}
+
+// lanes holds a lane for each Registration.
+type lanes struct {
+	capacity int
+	// registrations (optional) provides the current delivery
+	// policies, the notifications may carry outdated ones
+	registrations *RegistrationCache
+	httpClient    *http.Client
+
+	mu    sync.Mutex
+	items map[string]*lane
+}
+
+func newLanes(capacity int, registrations *RegistrationCache, httpClient *http.Client) *lanes {
+	if capacity <= 0 {
+		capacity = defaultLaneCapacity
+	}
+
+	return &lanes{
+		capacity:      capacity,
+		registrations: registrations,
+		httpClient:    httpClient,
+		items:         map[string]*lane{},
+	}
+}
+
+// submit queues the notification in the Registration lane without
+// blocking; done (optional) is called once the notification has been
+// handled. When the Registration delivery policy has changed, the
+// lane is updated in place.
+//
+// A notification not fitting in the lane is rejected, unless it has
+// a done function, i.e. it's acknowledged by a persistent queue once
+// handled: it then waits in the lane backlog, still unacknowledged.
+// The backlog holds the notifications the persistent queue keeps in
+// memory anyway, so --queue-max-bytes bounds it.
+func (l *lanes) submit(job *advisor, done func()) {
+	lj := &laneJob{adv: job, done: done}
+
+	for {
+		el := l.get(job.name, l.policy(job))
+
+		switch err := el.offer(lj, done != nil); err {
+		case nil:
+			return
+		case errLaneFull:
+			lj.drop()
+			return
+		}
+	}
+}
+
+// policy returns the current delivery policy of the notification
+// Registration; the one of the notification if it's not cached.
+func (l *lanes) policy(job *advisor) *v1alpha1.DeliveryPolicy {
+	if l.registrations != nil {
+		if el, ok := l.registrations.get(job.name); ok {
+			return el.spec.Delivery
+		}
+	}
+	return job.reg.Delivery
+}
+
+func (l *lanes) get(name string, policy *v1alpha1.DeliveryPolicy) *lane {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[name]
+	if !ok {
+		el = newLane(name, policy, l.capacity, l.httpClient)
+		l.items[name] = el
+	} else if el.changed(policy) {
+		el.update(policy)
+	}
+
+	return el
+}
+
+// dispatchers returns the lanes of the Registrations,
+// which report their delivery progress.
+func (l *lanes) dispatchers() map[string]health.Dispatcher {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := make(map[string]health.Dispatcher, len(l.items))
+	for name, el := range l.items {
+		res[name] = el
+	}
+	return res
+}
+
+// prune stops the lanes of the deleted Registrations;
+// the notifications in their backlogs are rejected, then
+// the Registration metrics are deleted.
+func (l *lanes) prune(all map[string]registration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for name, el := range l.items {
+		if _, ok := all[name]; !ok {
+			go func() {
+				for _, job := range el.stop() {
+					job.adv.reject(errRegistrationNotFound)
+					job.finish()
+				}
+				deleteRegistrationMetrics(name)
+			}()
+			delete(l.items, name)
+		}
+	}
+}
+
+// stop stops all the lanes, e.g. on shutdown, and waits for them;
+// the notifications in the backlogs are left unacknowledged, so
+// that the persistent queue restores them. The notifications submitted afterwards
+// start new lanes.
+func (l *lanes) stop() {
+	l.mu.Lock()
+	items := l.items
+	l.items = map[string]*lane{}
+	l.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, el := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			el.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+// probeEndpoint checks the health of an endpoint with a GET request.
+func probeEndpoint(httpClient *http.Client, endpoint string, timeout time.Duration) error {
+	ctx, cncl := context.WithTimeout(context.Background(), timeout)
+	defer cncl()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health probe of %s failed: status %d", endpoint, res.StatusCode)
+	}
+
+	return nil
+}
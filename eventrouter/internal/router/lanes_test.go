@@ -0,0 +1,341 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"github.com/krateoplatformops/eventrouter/internal/health"
+	"github.com/krateoplatformops/eventrouter/internal/helpers/queue"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLanesIsolation(t *testing.T) {
+	hang := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(hang)
+
+	var wg sync.WaitGroup
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer healthy.Close()
+
+	l := newLanes(10, nil, http.DefaultClient)
+	stats := newDeliveryStats()
+
+	submit := func(name, endpoint string) {
+		wg.Add(1)
+		l.submit(newAdvisor(advOpts{
+			httpClient:       http.DefaultClient,
+			registrationName: name,
+			registrationSpec: v1alpha1.RegistrationSpec{
+				Endpoint: endpoint,
+				Retry:    &v1alpha1.RetryPolicy{MaxAttempts: 1},
+				Delivery: &v1alpha1.DeliveryPolicy{
+					Timeout:        &metav1.Duration{Duration: 5 * time.Second},
+					MaxConcurrency: 1,
+				},
+			},
+			events:           []corev1.Event{{Reason: "LoremIpsum"}},
+			compositionIdKey: DefaultCompositionIDKey,
+			stats:            stats,
+		}), wg.Done)
+	}
+
+	for i := 0; i < 3; i++ {
+		submit("slow", slow.URL)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var hwg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			hwg.Add(1)
+			l.submit(newAdvisor(advOpts{
+				httpClient:       http.DefaultClient,
+				registrationName: "healthy",
+				registrationSpec: v1alpha1.RegistrationSpec{Endpoint: healthy.URL},
+				events:           []corev1.Event{{Reason: "LoremIpsum"}},
+				compositionIdKey: DefaultCompositionIDKey,
+				stats:            stats,
+			}), hwg.Done)
+		}
+		hwg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("healthy registration held up by the slow one")
+	}
+
+	if got := stats.drain()["healthy"].delivered; got != 5 {
+		t.Errorf("delivered: got %d, expected 5", got)
+	}
+}
+
+func TestLanesOverflow(t *testing.T) {
+	hang := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(hang)
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer healthy.Close()
+
+	l := newLanes(1, nil, http.DefaultClient)
+	stats := newDeliveryStats()
+	sink := &mockSink{}
+
+	advise := func(name, endpoint string) *advisor {
+		return newAdvisor(advOpts{
+			httpClient:       http.DefaultClient,
+			registrationName: name,
+			registrationSpec: v1alpha1.RegistrationSpec{
+				Endpoint: endpoint,
+				Retry:    &v1alpha1.RetryPolicy{MaxAttempts: 1},
+				Delivery: &v1alpha1.DeliveryPolicy{
+					Timeout:        &metav1.Duration{Duration: 5 * time.Second},
+					MaxConcurrency: 1,
+				},
+			},
+			events:           []corev1.Event{{Reason: "LoremIpsum"}},
+			compositionIdKey: DefaultCompositionIDKey,
+			deadLetters:      sink,
+			stats:            stats,
+		})
+	}
+
+	// a single worker and a single slot: the pushes past
+	// the first ones must be rejected instead of blocking
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			l.submit(advise("slow", slow.URL), nil)
+		}
+		l.submit(advise("healthy", healthy.URL), func() { close(done) })
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("healthy registration held up by the full lane")
+	}
+
+	got := stats.drain()
+	if n := got["healthy"].delivered; n != 1 {
+		t.Errorf("delivered: got %d, expected 1", n)
+	}
+	if n := got["slow"].failed; n < 7 {
+		t.Errorf("rejected: got %d, expected at least 7", n)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, e := range sink.all {
+		if e.Registration != "slow" || e.LastError != errLaneFull.Error() {
+			t.Errorf("unexpected dead letter: %+v", e)
+		}
+	}
+}
+
+func TestLanesPrune(t *testing.T) {
+	l := newLanes(1, nil, http.DefaultClient)
+	for _, name := range []string{"a", "b", "c"} {
+		l.get(name, nil)
+	}
+
+	// as many Registrations as lanes, but not the same ones
+	l.prune(map[string]registration{"a": {}, "d": {}, "e": {}})
+
+	if len(l.items) != 1 || l.items["a"] == nil {
+		t.Errorf("lanes: got %v, expected only a", l.items)
+	}
+}
+
+func TestLanesUpdate(t *testing.T) {
+	l := newLanes(1, nil, http.DefaultClient)
+
+	policy := &v1alpha1.DeliveryPolicy{
+		MaxConcurrency: 1,
+		CircuitBreaker: &v1alpha1.CircuitBreakerPolicy{FailureThreshold: 1},
+	}
+	el := l.get("foo", policy)
+	el.breaker.record(false)
+	if el.breaker.allow() {
+		t.Fatal("expected the circuit to be open")
+	}
+
+	for _, next := range []*v1alpha1.DeliveryPolicy{
+		// a re-fetched Registration
+		policy.DeepCopy(),
+		{
+			Timeout:        &metav1.Duration{Duration: time.Second},
+			MaxConcurrency: 1,
+			CircuitBreaker: &v1alpha1.CircuitBreakerPolicy{FailureThreshold: 2},
+		},
+		{MaxConcurrency: 2},
+	} {
+		if got := l.get("foo", next); got != el {
+			t.Fatal("expected the lane to be updated in place")
+		}
+		if el.breaker.allow() {
+			t.Error("expected the circuit to stay open")
+		}
+	}
+
+	if el.workers != 2 || el.breaker.threshold != defaultFailureThreshold {
+		t.Errorf("got %d workers and threshold %d, expected 2 and %d",
+			el.workers, el.breaker.threshold, defaultFailureThreshold)
+	}
+	el.stop()
+}
+
+func TestLanesDiskQueue(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	var delivered int32
+	done := make(chan struct{})
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&delivered, 1) == 3 {
+			close(done)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer healthy.Close()
+
+	// a single shared worker: it must never wait for the slow lane
+	dq, err := queue.NewDiskQueue(queue.DiskQueueOpts{
+		Dir:        t.TempDir(),
+		MaxWorkers: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dq.Run()
+	defer dq.Terminate()
+
+	l := newLanes(1, nil, http.DefaultClient)
+	stats := newDeliveryStats()
+	sink := &mockSink{}
+
+	advise := func(name, endpoint string) *advisor {
+		return newAdvisor(advOpts{
+			httpClient:       http.DefaultClient,
+			registrationName: name,
+			registrationSpec: v1alpha1.RegistrationSpec{
+				Endpoint: endpoint,
+				Retry:    &v1alpha1.RetryPolicy{MaxAttempts: 1},
+				Delivery: &v1alpha1.DeliveryPolicy{
+					Timeout:        &metav1.Duration{Duration: 5 * time.Second},
+					MaxConcurrency: 1,
+				},
+			},
+			events:           []corev1.Event{{Reason: "LoremIpsum"}},
+			compositionIdKey: DefaultCompositionIDKey,
+			deadLetters:      sink,
+			stats:            stats,
+			lanes:            l,
+		})
+	}
+
+	// one being delivered, one held by the dispatcher,
+	// one queued and three waiting in the backlog
+	for i := 0; i < 6; i++ {
+		dq.Push(advise("slow", slow.URL))
+	}
+	for i := 0; i < 3; i++ {
+		dq.Push(advise("healthy", healthy.URL))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("healthy registration held up by the slow one: %d delivered", atomic.LoadInt32(&delivered))
+	}
+
+	// the slow notifications are neither rejected nor acknowledged
+	if dq.GetBytes() == 0 {
+		t.Error("expected the slow notifications to be still pending")
+	}
+
+	// the backlog counts as waiting for the liveness check
+	if n := l.dispatchers()["slow"].GetJobCount(); n != 4 {
+		t.Errorf("slow lane jobs: got %d, expected 4", n)
+	}
+	err = health.ProgressAll(l.dispatchers, time.Nanosecond)()
+	if err == nil || !strings.HasPrefix(err.Error(), "slow: ") {
+		t.Errorf("got error %v, expected the slow lane stuck", err)
+	}
+
+	close(release)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for dq.GetBytes() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("notifications still pending: %d bytes", dq.GetBytes())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if n := stats.drain()["slow"].delivered; n != 6 {
+		t.Errorf("delivered: got %d, expected 6", n)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.all) != 0 {
+		t.Errorf("unexpected dead letters: %+v", sink.all)
+	}
+}
+
+func TestLanesPolicy(t *testing.T) {
+	current := &v1alpha1.DeliveryPolicy{MaxConcurrency: 2}
+	l := newLanes(1, &RegistrationCache{items: map[string]registration{
+		"foo": {name: "foo", spec: v1alpha1.RegistrationSpec{Delivery: current}},
+	}}, http.DefaultClient)
+
+	// queued before the Registration was edited
+	outdated := &v1alpha1.DeliveryPolicy{MaxConcurrency: 1}
+	advise := func(name string) *advisor {
+		return newAdvisor(advOpts{
+			registrationName: name,
+			registrationSpec: v1alpha1.RegistrationSpec{Delivery: outdated},
+		})
+	}
+
+	if got := l.policy(advise("foo")); got != current {
+		t.Errorf("got %+v, expected the cached policy", got)
+	}
+	if got := l.policy(advise("bar")); got != outdated {
+		t.Errorf("got %+v, expected the notification policy", got)
+	}
+}
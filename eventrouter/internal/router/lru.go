@@ -0,0 +1,87 @@
+package router
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a fixed size cache evicting the least recently
+// used entries; entries older than the TTL are never returned.
+type lruCache[K comparable, V any] struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	val     V
+	expires time.Time
+}
+
+func newLRUCache[K comparable, V any](size int, ttl time.Duration) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		ll:    list.New(),
+		items: map[K]*list.Element{},
+	}
+}
+
+func (c *lruCache[K, V]) get(key K) (val V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return val, false
+	}
+
+	ent := el.Value.(*lruEntry[K, V])
+	if c.now().After(ent.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return val, false
+	}
+
+	c.ll.MoveToFront(el)
+	return ent.val, true
+}
+
+func (c *lruCache[K, V]) set(key K, val V) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+
+	if el, ok := c.items[key]; ok {
+		ent := el.Value.(*lruEntry[K, V])
+		ent.val, ent.expires = val, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry[K, V]{key: key, val: val, expires: expires})
+
+	for c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+func (c *lruCache[K, V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,37 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+
+	c := newLRUCache[string, int](2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.set("a", 1)
+	c.set("b", 2)
+
+	// "a" becomes the most recently used, so "b" is evicted
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("a: got (%d, %t), expected (1, true)", v, ok)
+	}
+	c.set("c", 3)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b: expected evicted")
+	}
+	if c.len() != 2 {
+		t.Errorf("len: got %d, expected 2", c.len())
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get("c"); ok {
+		t.Error("c: expected expired")
+	}
+	if c.len() != 1 {
+		t.Errorf("len: got %d, expected 1", c.len())
+	}
+}
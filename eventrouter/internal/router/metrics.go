@@ -0,0 +1,91 @@
+package router
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	informerEvents        = "events"
+	informerRegistrations = "registrations"
+)
+
+// deliveryBuckets are the buckets of the delivery durations (seconds),
+// up to the default delivery timeout.
+var deliveryBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var (
+	eventsReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventrouter_events_received_total",
+		Help: "Number of events received from the informer.",
+	})
+	eventsSuppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventrouter_events_suppressed_total",
+		Help: "Number of repeated events suppressed by the dedup window.",
+	})
+	eventsSkipped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventrouter_events_skipped_total",
+		Help: "Number of events found at startup skipped by the replay policy.",
+	})
+	eventsFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_events_filtered_total",
+		Help: "Number of events discarded by the registration filter.",
+	}, []string{"registration"})
+	eventsDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_events_delivered_total",
+		Help: "Number of events delivered to the registration endpoint.",
+	}, []string{"registration"})
+	eventsUndelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_events_undelivered_total",
+		Help: "Number of events not delivered after all the attempts.",
+	}, []string{"registration"})
+	circuitBreakerOpened = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_circuit_breaker_opened_total",
+		Help: "Number of times the circuit breaker of a registration has been opened.",
+	}, []string{"registration"})
+	laneOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_lane_overflows_total",
+		Help: "Number of notifications rejected because the registration lane was full.",
+	}, []string{"registration"})
+	deliveryFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_delivery_failures_total",
+		Help: "Number of failed delivery attempts.",
+	}, []string{"registration"})
+	deliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eventrouter_delivery_duration_seconds",
+		Help:    "Duration of the delivery attempts.",
+		Buckets: deliveryBuckets,
+	}, []string{"registration"})
+	compositionIdDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "eventrouter_composition_id_resolution_duration_seconds",
+		Help: "Duration of the composition identifier resolution.",
+	})
+	compositionCacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_composition_cache_hits_total",
+		Help: "Number of composition identifier lookups served by the cache.",
+	}, []string{"cache"})
+	compositionCacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_composition_cache_misses_total",
+		Help: "Number of composition identifier lookups not found in the cache.",
+	}, []string{"cache"})
+	informerResyncs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_informer_resyncs_total",
+		Help: "Number of objects redelivered by the informer periodic resync.",
+	}, []string{"informer"})
+)
+
+// deleteRegistrationMetrics deletes the series of a deleted
+// Registration, which would be exported forever otherwise.
+func deleteRegistrationMetrics(name string) {
+	for _, el := range []*prometheus.MetricVec{
+		eventsFiltered.MetricVec,
+		eventsDelivered.MetricVec,
+		eventsUndelivered.MetricVec,
+		circuitBreakerOpened.MetricVec,
+		laneOverflows.MetricVec,
+		deliveryFailures.MetricVec,
+		deliveryDuration.MetricVec,
+	} {
+		el.DeleteLabelValues(name)
+	}
+}
@@ -0,0 +1,179 @@
+package router
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+type eventInformer struct {
+	informer cache.SharedInformer
+	// reg tells when the initial list has been handled
+	reg  cache.ResourceEventHandlerRegistration
+	stop chan struct{}
+}
+
+// eventInformers runs an events informer for each watched namespace.
+type eventInformers struct {
+	restClient     rest.Interface
+	objType        runtime.Object
+	fieldSelector  fields.Selector
+	resyncInterval time.Duration
+	handler        cache.ResourceEventHandler
+	// onStart (optional) is called before an informer is started
+	onStart func(ns string)
+
+	mu    sync.Mutex
+	items map[string]*eventInformer
+}
+
+// start runs the informer of the namespace, if not already running.
+func (ei *eventInformers) start(ns string) {
+	ei.mu.Lock()
+	defer ei.mu.Unlock()
+
+	if _, ok := ei.items[ns]; ok {
+		return
+	}
+
+	lw := cache.NewListWatchFromClient(ei.restClient, "events", ns, ei.fieldSelector)
+
+	el := &eventInformer{
+		informer: cache.NewSharedInformer(lw, ei.objType, ei.resyncInterval),
+		stop:     make(chan struct{}),
+	}
+	// fails only if the informer has been stopped
+	el.reg, _ = el.informer.AddEventHandler(ei.handler)
+	ei.items[ns] = el
+
+	if ei.onStart != nil {
+		ei.onStart(ns)
+	}
+
+	klog.InfoS("Watching events", "namespace", ns, "fieldSelector", ei.fieldSelector.String())
+
+	go func() {
+		defer utilruntime.HandleCrash()
+		el.informer.Run(el.stop)
+	}()
+}
+
+// stop terminates the informer of the namespace.
+func (ei *eventInformers) stop(ns string) {
+	ei.mu.Lock()
+	defer ei.mu.Unlock()
+
+	el, ok := ei.items[ns]
+	if !ok {
+		return
+	}
+
+	close(el.stop)
+	delete(ei.items, ns)
+
+	klog.InfoS("Stopped watching events", "namespace", ns)
+}
+
+func (ei *eventInformers) stopAll() {
+	ei.mu.Lock()
+	defer ei.mu.Unlock()
+
+	for ns, el := range ei.items {
+		close(el.stop)
+		delete(ei.items, ns)
+	}
+}
+
+func (ei *eventInformers) hasSynced() bool {
+	ei.mu.Lock()
+	defer ei.mu.Unlock()
+
+	for _, el := range ei.items {
+		if !el.reg.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// namespaceWatcher starts and stops the events informers as
+// the namespaces start or stop matching the label selector.
+type namespaceWatcher struct {
+	informer  cache.SharedInformer
+	reg       cache.ResourceEventHandlerRegistration
+	allowed   []string
+	selector  labels.Selector
+	informers *eventInformers
+}
+
+func newNamespaceWatcher(restClient rest.Interface, allowed []string, selector labels.Selector, informers *eventInformers) *namespaceWatcher {
+	lw := cache.NewListWatchFromClient(restClient, "namespaces", "", fields.Everything())
+
+	res := &namespaceWatcher{
+		informer:  cache.NewSharedInformer(lw, &corev1.Namespace{}, 0),
+		allowed:   allowed,
+		selector:  selector,
+		informers: informers,
+	}
+
+	// fails only if the informer has been stopped
+	res.reg, _ = res.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    res.sync,
+		UpdateFunc: func(_, obj interface{}) { res.sync(obj) },
+		DeleteFunc: res.onDelete,
+	})
+
+	return res
+}
+
+func (nw *namespaceWatcher) run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	nw.informer.Run(stopCh)
+}
+
+// hasSynced tells whether the initial list of namespaces has been
+// handled, i.e. the informers of the matching ones have been started.
+func (nw *namespaceWatcher) hasSynced() bool {
+	return nw.reg.HasSynced()
+}
+
+// wanted tells whether the events of the namespace have to be watched.
+func (nw *namespaceWatcher) wanted(ns *corev1.Namespace) bool {
+	if len(nw.allowed) > 0 && !slices.Contains(nw.allowed, ns.Name) {
+		return false
+	}
+	return nw.selector.Matches(labels.Set(ns.Labels))
+}
+
+func (nw *namespaceWatcher) sync(obj interface{}) {
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		return
+	}
+
+	if nw.wanted(ns) {
+		nw.informers.start(ns.Name)
+	} else {
+		nw.informers.stop(ns.Name)
+	}
+}
+
+func (nw *namespaceWatcher) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	if ns, ok := obj.(*corev1.Namespace); ok {
+		nw.informers.stop(ns.Name)
+	}
+}
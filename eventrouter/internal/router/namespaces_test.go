@@ -0,0 +1,82 @@
+package router
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNamespaceWatcherWanted(t *testing.T) {
+	tenant, err := labels.Parse("krateo.io/tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		ns      string
+		labels  map[string]string
+		exp     bool
+	}{
+		{"matching selector", nil, "tenant-a", map[string]string{"krateo.io/tenant": "a"}, true},
+		{"not matching selector", nil, "kube-system", nil, false},
+		{"matching selector and list", []string{"tenant-a"}, "tenant-a", map[string]string{"krateo.io/tenant": "a"}, true},
+		{"matching selector not in list", []string{"tenant-a"}, "tenant-b", map[string]string{"krateo.io/tenant": "b"}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			nw := &namespaceWatcher{allowed: tc.allowed, selector: tenant}
+
+			got := nw.wanted(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: tc.ns, Labels: tc.labels},
+			})
+			if got != tc.exp {
+				t.Errorf("got %t, expected %t", got, tc.exp)
+			}
+		})
+	}
+}
+
+// syncedRegistration is the handler registration of a fake informer.
+type syncedRegistration struct {
+	cache.ResourceEventHandlerRegistration
+	synced bool
+}
+
+func (r *syncedRegistration) HasSynced() bool { return r.synced }
+
+func TestEventRouterHasSynced(t *testing.T) {
+	demo := &syncedRegistration{}
+	er := &EventRouter{
+		informers: &eventInformers{items: map[string]*eventInformer{
+			"demo": {reg: demo},
+		}},
+	}
+
+	demo.synced = true
+	if er.HasSynced() {
+		t.Fatal("synced before the informers have been started")
+	}
+
+	er.started.Store(true)
+	demo.synced = false
+	if er.HasSynced() {
+		t.Fatal("synced before the initial list")
+	}
+
+	demo.synced = true
+	if !er.HasSynced() {
+		t.Fatal("expected the initial list to be completed")
+	}
+
+	// a namespace matching the selector later on
+	er.informers.items["other"] = &eventInformer{reg: &syncedRegistration{}}
+	if !er.HasSynced() {
+		t.Error("expected the readiness to be kept")
+	}
+}
@@ -0,0 +1,204 @@
+package router
+
+import (
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/krateoplatformops/eventrouter/internal/objects"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	cacheObjects = "objects"
+	cacheOwners  = "owners"
+)
+
+// resolvedObject is the outcome of the resolution of an involved object.
+type resolvedObject struct {
+	cid string
+	// labels are all the involved object labels.
+	labels map[string]string
+	// enrichLabels and enrichAnnotations are the metadata
+	// to be copied onto the forwarded event.
+	enrichLabels      map[string]string
+	enrichAnnotations map[string]string
+}
+
+// compositionResolver finds the composition identifier of the events
+// involved objects; objects without the composition label inherit the
+// identifier of the closest labeled owner. The enrichment metadata
+// missing in the involved object are taken from the same owner.
+//
+// The outcomes, negative ones included, are cached by involved object
+// UID and resourceVersion; the owners are cached by UID.
+type compositionResolver struct {
+	get      func(ref *corev1.ObjectReference) (*unstructured.Unstructured, error)
+	keys     Enrichment
+	maxDepth int
+	objects  *lruCache[string, resolvedObject]
+	owners   *lruCache[types.UID, resolvedObject]
+}
+
+func newCompositionResolver(resolver *objects.ObjectResolver, keys Enrichment, maxDepth, cacheSize int, cacheTTL time.Duration) *compositionResolver {
+	return &compositionResolver{
+		get: func(ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
+			return resolveObject(resolver, ref)
+		},
+		keys:     keys,
+		maxDepth: maxDepth,
+		objects:  newLRUCache[string, resolvedObject](cacheSize, cacheTTL),
+		owners:   newLRUCache[types.UID, resolvedObject](cacheSize, cacheTTL),
+	}
+}
+
+// find returns the composition identifier and the enrichment
+// metadata of the event involved object.
+func (r *compositionResolver) find(ref *corev1.ObjectReference) (resolvedObject, error) {
+	if len(ref.UID) == 0 {
+		return r.resolve(ref)
+	}
+
+	key := string(ref.UID) + "/" + ref.ResourceVersion
+	if el, ok := r.objects.get(key); ok {
+		compositionCacheHits.WithLabelValues(cacheObjects).Inc()
+		return el, nil
+	}
+	compositionCacheMisses.WithLabelValues(cacheObjects).Inc()
+
+	res, err := r.resolve(ref)
+	if err != nil {
+		return resolvedObject{}, err
+	}
+
+	r.objects.set(key, res)
+	return res, nil
+}
+
+// resolve fetches the involved object and, if it is not
+// labeled, looks for the composition identifier in its owners.
+func (r *compositionResolver) resolve(ref *corev1.ObjectReference) (resolvedObject, error) {
+	obj, err := r.get(ref)
+	if err != nil {
+		return resolvedObject{}, err
+	}
+
+	if obj == nil {
+		klog.V(4).InfoS("object not found resolving reference",
+			"name", ref.Name,
+			"kind", ref.Kind,
+			"apiVersion", ref.APIVersion)
+		return resolvedObject{}, nil
+	}
+
+	res := r.pick(obj)
+	if len(res.cid) > 0 {
+		klog.V(4).InfoS("labels found in resolved reference",
+			"labels", spew.Sdump(res.labels))
+		return res, nil
+	}
+
+	owner, err := r.fromOwners(obj)
+	if err != nil {
+		return resolvedObject{}, err
+	}
+
+	res.cid = owner.cid
+	res.enrichLabels = merge(res.enrichLabels, owner.enrichLabels)
+	res.enrichAnnotations = merge(res.enrichAnnotations, owner.enrichAnnotations)
+
+	return res, nil
+}
+
+// pick returns the composition identifier and the
+// enrichment metadata found in the object.
+func (r *compositionResolver) pick(obj *unstructured.Unstructured) resolvedObject {
+	labels := obj.GetLabels()
+
+	return resolvedObject{
+		cid:               labels[r.keys.compositionIDKey()],
+		labels:            labels,
+		enrichLabels:      selectKeys(labels, r.keys.Labels),
+		enrichAnnotations: selectKeys(obj.GetAnnotations(), r.keys.Annotations),
+	}
+}
+
+// fromOwners follows the ownerReferences upward, up to maxDepth
+// levels, looking for an owner with the composition label. All the
+// visited owners are cached with the result, so that the siblings
+// of the object don't walk the same chain again.
+func (r *compositionResolver) fromOwners(obj *unstructured.Unstructured) (resolvedObject, error) {
+	var visited []types.UID
+
+	cur := obj
+	for depth := 0; depth < r.maxDepth; depth++ {
+		owner := ownerOf(cur)
+		if owner == nil {
+			break
+		}
+
+		if res, ok := r.owners.get(owner.UID); ok {
+			compositionCacheHits.WithLabelValues(cacheOwners).Inc()
+			r.cacheOwners(visited, res)
+			return res, nil
+		}
+		compositionCacheMisses.WithLabelValues(cacheOwners).Inc()
+		visited = append(visited, owner.UID)
+
+		parent, err := r.get(&corev1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			Namespace:  cur.GetNamespace(),
+		})
+		if err != nil {
+			return resolvedObject{}, err
+		}
+		if parent == nil || parent.GetUID() != owner.UID {
+			break
+		}
+
+		if res := r.pick(parent); len(res.cid) > 0 {
+			klog.V(4).InfoS("composition id found in owner",
+				"name", obj.GetName(),
+				"kind", obj.GetKind(),
+				"owner", parent.GetName(),
+				"ownerKind", parent.GetKind(),
+				"depth", depth+1)
+			// the owner labels are not needed by the filters
+			res.labels = nil
+			r.cacheOwners(visited, res)
+			return res, nil
+		}
+
+		cur = parent
+	}
+
+	r.cacheOwners(visited, resolvedObject{})
+	return resolvedObject{}, nil
+}
+
+func (r *compositionResolver) cacheOwners(uids []types.UID, res resolvedObject) {
+	for _, uid := range uids {
+		r.owners.set(uid, res)
+	}
+}
+
+// ownerOf returns the controller reference of the object,
+// or its first owner reference if there is no controller.
+func ownerOf(obj *unstructured.Unstructured) *metav1.OwnerReference {
+	refs := obj.GetOwnerReferences()
+	if len(refs) == 0 {
+		return nil
+	}
+
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	return &refs[0]
+}
@@ -0,0 +1,213 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newFakeObject(kind, name, uid, cid string, owner *unstructured.Unstructured) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind(kind)
+	obj.SetName(name)
+	obj.SetNamespace("demo-system")
+	obj.SetUID(types.UID(uid))
+	if len(cid) > 0 {
+		obj.SetLabels(map[string]string{DefaultCompositionIDKey: cid})
+	}
+	if owner != nil {
+		controller := true
+		obj.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: owner.GetAPIVersion(),
+			Kind:       owner.GetKind(),
+			Name:       owner.GetName(),
+			UID:        owner.GetUID(),
+			Controller: &controller,
+		}})
+	}
+	return obj
+}
+
+func TestCompositionResolverFind(t *testing.T) {
+	deploy := newFakeObject("Deployment", "app", "uid-deploy", "abcde12345", nil)
+	rs := newFakeObject("ReplicaSet", "app-6b5d", "uid-rs", "", deploy)
+	pod1 := newFakeObject("Pod", "app-6b5d-x1", "uid-pod-1", "", rs)
+	pod2 := newFakeObject("Pod", "app-6b5d-x2", "uid-pod-2", "", rs)
+	orphan := newFakeObject("Pod", "orphan", "uid-orphan", "", nil)
+
+	all := map[string]*unstructured.Unstructured{}
+	for _, el := range []*unstructured.Unstructured{deploy, rs, pod1, pod2, orphan} {
+		all[el.GetName()] = el
+	}
+
+	tests := []struct {
+		name     string
+		maxDepth int
+		ref      string
+		exp      string
+		expGets  int
+	}{
+		{"labeled object", 5, "app", "abcde12345", 1},
+		{"orphan", 5, "orphan", "", 1},
+		{"walk disabled", 0, "app-6b5d-x1", "", 1},
+		{"depth too short", 1, "app-6b5d-x1", "", 2},
+		{"walk to the deployment", 5, "app-6b5d-x1", "abcde12345", 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gets := 0
+			r := &compositionResolver{
+				get: func(ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
+					gets++
+					return all[ref.Name], nil
+				},
+				maxDepth: tc.maxDepth,
+				objects:  newLRUCache[string, resolvedObject](10, time.Minute),
+				owners:   newLRUCache[types.UID, resolvedObject](10, time.Minute),
+			}
+
+			res, err := r.find(&corev1.ObjectReference{Name: tc.ref})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.cid != tc.exp {
+				t.Errorf("composition id: got %q, expected %q", res.cid, tc.exp)
+			}
+			if gets != tc.expGets {
+				t.Errorf("lookups: got %d, expected %d", gets, tc.expGets)
+			}
+		})
+	}
+
+	t.Run("siblings use the cached chain", func(t *testing.T) {
+		gets := 0
+		r := &compositionResolver{
+			get: func(ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
+				gets++
+				return all[ref.Name], nil
+			},
+			maxDepth: 5,
+			objects:  newLRUCache[string, resolvedObject](10, time.Minute),
+			owners:   newLRUCache[types.UID, resolvedObject](10, time.Minute),
+		}
+
+		if _, err := r.find(&corev1.ObjectReference{Name: "app-6b5d-x1"}); err != nil {
+			t.Fatal(err)
+		}
+
+		gets = 0
+		res, err := r.find(&corev1.ObjectReference{Name: "app-6b5d-x2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.cid != "abcde12345" {
+			t.Errorf("composition id: got %q, expected %q", res.cid, "abcde12345")
+		}
+		if gets != 1 {
+			t.Errorf("lookups: got %d, expected 1", gets)
+		}
+	})
+}
+
+func TestCompositionResolverCache(t *testing.T) {
+	gets := 0
+	r := &compositionResolver{
+		get: func(ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
+			gets++
+			if ref.Name == "missing" {
+				return nil, nil
+			}
+			return newFakeObject("Pod", ref.Name, string(ref.UID), "abcde12345", nil), nil
+		},
+		maxDepth: 5,
+		objects:  newLRUCache[string, resolvedObject](10, time.Minute),
+		owners:   newLRUCache[types.UID, resolvedObject](10, time.Minute),
+	}
+
+	tests := []struct {
+		ref     corev1.ObjectReference
+		exp     string
+		expGets int
+	}{
+		{corev1.ObjectReference{Name: "app", UID: "uid-app", ResourceVersion: "1"}, "abcde12345", 1},
+		{corev1.ObjectReference{Name: "app", UID: "uid-app", ResourceVersion: "1"}, "abcde12345", 1},
+		{corev1.ObjectReference{Name: "app", UID: "uid-app", ResourceVersion: "2"}, "abcde12345", 2},
+		{corev1.ObjectReference{Name: "missing", UID: "uid-missing", ResourceVersion: "1"}, "", 3},
+		{corev1.ObjectReference{Name: "missing", UID: "uid-missing", ResourceVersion: "1"}, "", 3},
+	}
+
+	for i, tc := range tests {
+		res, err := r.find(&tc.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.cid != tc.exp {
+			t.Errorf("#%d composition id: got %q, expected %q", i, res.cid, tc.exp)
+		}
+		if gets != tc.expGets {
+			t.Errorf("#%d lookups: got %d, expected %d", i, gets, tc.expGets)
+		}
+	}
+}
+
+func TestCompositionResolverEnrichment(t *testing.T) {
+	deploy := newFakeObject("Deployment", "app", "uid-deploy", "abcde12345", nil)
+	deploy.SetLabels(map[string]string{
+		"krateo.io/composition-id":   "abcde12345",
+		"krateo.io/composition-name": "demo",
+		"krateo.io/tenant":           "acme",
+	})
+	deploy.SetAnnotations(map[string]string{
+		"krateo.io/blueprint-version": "1.2.0",
+	})
+
+	pod := newFakeObject("Pod", "app-x1", "uid-pod", "", deploy)
+	pod.SetLabels(map[string]string{
+		"krateo.io/tenant": "acme-dev",
+	})
+
+	all := map[string]*unstructured.Unstructured{"app": deploy, "app-x1": pod}
+
+	r := &compositionResolver{
+		get: func(ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
+			return all[ref.Name], nil
+		},
+		keys: Enrichment{
+			Labels:      []string{"krateo.io/composition-name", "krateo.io/tenant"},
+			Annotations: []string{"krateo.io/blueprint-version"},
+		},
+		maxDepth: 5,
+		objects:  newLRUCache[string, resolvedObject](10, time.Minute),
+		owners:   newLRUCache[types.UID, resolvedObject](10, time.Minute),
+	}
+
+	res, err := r.find(&corev1.ObjectReference{Name: "app-x1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expLabels := map[string]string{
+		"krateo.io/composition-name": "demo",
+		// the involved object values win over the owner ones
+		"krateo.io/tenant": "acme-dev",
+	}
+	if !reflect.DeepEqual(res.enrichLabels, expLabels) {
+		t.Errorf("labels: got %v, expected %v", res.enrichLabels, expLabels)
+	}
+
+	expAnnotations := map[string]string{"krateo.io/blueprint-version": "1.2.0"}
+	if !reflect.DeepEqual(res.enrichAnnotations, expAnnotations) {
+		t.Errorf("annotations: got %v, expected %v", res.enrichAnnotations, expAnnotations)
+	}
+
+	if !reflect.DeepEqual(res.labels, pod.GetLabels()) {
+		t.Errorf("object labels: got %v, expected %v", res.labels, pod.GetLabels())
+	}
+}
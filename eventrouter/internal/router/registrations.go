@@ -0,0 +1,196 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// registration is a Registration with its compiled event filter.
+type registration struct {
+	name       string
+	generation int64
+	spec       v1alpha1.RegistrationSpec
+	status     v1alpha1.RegistrationStatus
+	filter     *eventFilter
+}
+
+type RegistrationCacheOpts struct {
+	RESTConfig     *rest.Config
+	ResyncInterval time.Duration
+}
+
+// RegistrationCache keeps a local copy of all the Registration objects
+// in sync with the API server using a shared informer.
+type RegistrationCache struct {
+	informer cache.SharedIndexInformer
+
+	mu    sync.RWMutex
+	items map[string]registration
+	// removed are called with the name of each
+	// Registration removed from the cache
+	removed []func(name string)
+}
+
+// NewRegistrationCache creates an informer backed Registration cache.
+func NewRegistrationCache(opts RegistrationCacheOpts) (*RegistrationCache, error) {
+	dynamicClient, err := dynamic.NewForConfig(opts.RESTConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRegistrationCache(dynamicClient, opts.ResyncInterval)
+}
+
+func newRegistrationCache(dynamicClient dynamic.Interface, resyncInterval time.Duration) (*RegistrationCache, error) {
+	si := dynamicinformer.NewFilteredDynamicInformer(dynamicClient,
+		v1alpha1.SchemeGroupVersion.WithResource("registrations"),
+		"", resyncInterval,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		nil)
+
+	rc := &RegistrationCache{
+		informer: si.Informer(),
+		items:    map[string]registration{},
+	}
+
+	_, err := rc.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    rc.onAddOrUpdate,
+		UpdateFunc: rc.onUpdate,
+		DeleteFunc: rc.onDelete,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+// Run starts the informer and blocks until stopCh is closed.
+func (rc *RegistrationCache) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	rc.informer.Run(stopCh)
+}
+
+// HasSynced returns true once the initial list of Registrations
+// has been loaded.
+func (rc *RegistrationCache) HasSynced() bool {
+	return rc.informer.HasSynced()
+}
+
+// onRemove registers a function called, out of the cache lock,
+// with the name of each Registration removed from the cache.
+func (rc *RegistrationCache) onRemove(fn func(name string)) {
+	rc.mu.Lock()
+	rc.removed = append(rc.removed, fn)
+	rc.mu.Unlock()
+}
+
+// get returns the cached registration.
+func (rc *RegistrationCache) get(name string) (registration, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	el, ok := rc.items[name]
+	return el, ok
+}
+
+// all returns a snapshot of the cached registrations keyed by name.
+func (rc *RegistrationCache) all() map[string]registration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	res := make(map[string]registration, len(rc.items))
+	for k, v := range rc.items {
+		res[k] = v
+	}
+	return res
+}
+
+func (rc *RegistrationCache) onUpdate(oldObj, newObj interface{}) {
+	if isResync(oldObj, newObj) {
+		informerResyncs.WithLabelValues(informerRegistrations).Inc()
+	}
+	rc.onAddOrUpdate(newObj)
+}
+
+func (rc *RegistrationCache) onAddOrUpdate(obj interface{}) {
+	reg, err := toRegistration(obj)
+	if err != nil {
+		klog.ErrorS(err, "unable to decode registration")
+		return
+	}
+
+	filter, err := newEventFilter(reg.Spec.Filter)
+	if err != nil {
+		klog.ErrorS(err, "unable to compile 'filter' attribute",
+			"registration", reg.Name)
+		rc.remove(reg.Name)
+		return
+	}
+
+	rc.mu.Lock()
+	rc.items[reg.Name] = registration{
+		name:       reg.Name,
+		generation: reg.Generation,
+		spec:       reg.Spec,
+		status:     reg.Status,
+		filter:     filter,
+	}
+	rc.mu.Unlock()
+
+	klog.V(4).InfoS("registration cached", "registration", reg.Name)
+}
+
+func (rc *RegistrationCache) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	reg, err := toRegistration(obj)
+	if err != nil {
+		klog.ErrorS(err, "unable to decode deleted registration")
+		return
+	}
+
+	rc.remove(reg.Name)
+
+	klog.V(4).InfoS("registration removed", "registration", reg.Name)
+}
+
+func (rc *RegistrationCache) remove(name string) {
+	rc.mu.Lock()
+	delete(rc.items, name)
+	removed := rc.removed
+	rc.mu.Unlock()
+
+	for _, fn := range removed {
+		fn(name)
+	}
+}
+
+func toRegistration(obj interface{}) (*v1alpha1.Registration, error) {
+	uns, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type: %T", obj)
+	}
+
+	var reg v1alpha1.Registration
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(uns.Object, &reg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert registration %q: %w", uns.GetName(), err)
+	}
+
+	return &reg, nil
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newFakeRegistration(t *testing.T, name string, filter *v1alpha1.RegistrationFilter) *unstructured.Unstructured {
+	t.Helper()
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&v1alpha1.Registration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       v1alpha1.RegistrationKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.RegistrationSpec{
+			ServiceName: name,
+			Endpoint:    "http://" + name + ".demo-system.svc/handle",
+			Filter:      filter,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func TestRegistrationCache(t *testing.T) {
+	gvr := v1alpha1.SchemeGroupVersion.WithResource("registrations")
+	invalid := &v1alpha1.RegistrationFilter{Reasons: []string{"(Failed"}}
+
+	cli := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: v1alpha1.RegistrationKind + "List"},
+		newFakeRegistration(t, "foo", nil),
+		newFakeRegistration(t, "bar", &v1alpha1.RegistrationFilter{Reasons: []string{"^Failed"}}),
+		newFakeRegistration(t, "baz", invalid),
+	)
+
+	rc, err := newRegistrationCache(cli, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu      sync.Mutex
+		removed []string
+	)
+	rc.onRemove(func(name string) {
+		mu.Lock()
+		removed = append(removed, name)
+		mu.Unlock()
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go rc.Run(stop)
+
+	if !cache.WaitForCacheSync(stop, rc.HasSynced) {
+		t.Fatal("timed out waiting for the cache to sync")
+	}
+
+	all := rc.all()
+	if len(all) != 2 || all["foo"].spec.Endpoint != "http://foo.demo-system.svc/handle" || all["bar"].filter == nil {
+		t.Fatalf("registrations: got %v", all)
+	}
+
+	// the snapshot is a copy
+	delete(all, "foo")
+	if _, ok := rc.get("foo"); !ok {
+		t.Error("snapshot changes must not affect the cache")
+	}
+
+	// an invalid filter removes the Registration
+	res := cli.Resource(gvr)
+	_, err = res.Update(context.Background(), newFakeRegistration(t, "bar", invalid), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = res.Delete(context.Background(), "foo", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true,
+		func(context.Context) (bool, error) {
+			return len(rc.all()) == 0, nil
+		})
+	if err != nil {
+		t.Fatalf("registrations not removed: %v", rc.all())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// baz is removed by the initial list too
+	if len(removed) != 3 {
+		t.Errorf("removed: got %v, expected baz, bar and foo", removed)
+	}
+}
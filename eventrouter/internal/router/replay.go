@@ -0,0 +1,57 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/krateoplatformops/eventrouter/internal/deadletter"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	errRegistrationNotFound = errors.New("registration not found")
+	errTooManyAttempts      = errors.New("too many delivery attempts")
+)
+
+// replayDeadLetters re-queues the stored notifications whose
+// Registration still exists and that have not exceeded the maximum
+// delivery attempts; the others are archived.
+func (c *pusher) replayDeadLetters() {
+	if !c.registrations.HasSynced() {
+		return
+	}
+
+	all := c.registrations.all()
+
+	orphaned, exhausted := 0, 0
+	tot, err := c.deadLetters.Replay(func(e deadletter.Entry) error {
+		el, ok := all[e.Registration]
+		if !ok {
+			orphaned++
+			return fmt.Errorf("%w: %w", deadletter.ErrArchive, errRegistrationNotFound)
+		}
+
+		if c.deadLetterMaxAttempts > 0 && e.Attempts >= c.deadLetterMaxAttempts {
+			exhausted++
+			return fmt.Errorf("%w: %w", deadletter.ErrArchive, errTooManyAttempts)
+		}
+
+		c.notifyQueue.Push(c.advisor(e.Registration, el.spec, []corev1.Event{e.Event}, e.Attempts))
+		return nil
+	})
+	if err != nil {
+		klog.ErrorS(err, "unable to replay dead letters")
+	}
+
+	if tot > 0 {
+		klog.InfoS("dead letters replayed", "count", tot)
+	}
+	if orphaned > 0 {
+		klog.InfoS("dead letters archived, registration not found", "count", orphaned)
+	}
+	if exhausted > 0 {
+		klog.InfoS("dead letters archived, too many delivery attempts",
+			"count", exhausted, "maxAttempts", c.deadLetterMaxAttempts)
+	}
+}
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	defaultMaxAttempts   = 3
+	defaultBackoff       = time.Second
+	defaultMaxBackoff    = 30 * time.Second
+	defaultJitterPercent = 10
+)
+
+// deliveryError describes a failed notification attempt.
+// A zero statusCode means that no response was received.
+type deliveryError struct {
+	statusCode int
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *deliveryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deliveryError) Unwrap() error {
+	return e.err
+}
+
+// isRetryable reports whether the notification attempt that
+// returned err is worth retrying (transport errors, 5xx and 429).
+func isRetryable(err error) bool {
+	var de *deliveryError
+	if !errors.As(err, &de) {
+		return false
+	}
+
+	return de.statusCode == 0 ||
+		de.statusCode == http.StatusTooManyRequests ||
+		de.statusCode >= http.StatusInternalServerError
+}
+
+// retryAfter returns the delay suggested by the subscriber, if any.
+func retryAfter(err error) time.Duration {
+	var de *deliveryError
+	if errors.As(err, &de) {
+		return de.retryAfter
+	}
+	return 0
+}
+
+// retryDelay returns the delay before the next attempt: the next
+// backoff step, or the delay suggested by the subscriber if longer,
+// capped by the maximum backoff (if any).
+func retryDelay(backoff *wait.Backoff, err error) time.Duration {
+	delay := backoff.Step()
+	if ra := retryAfter(err); ra > delay {
+		delay = ra
+		if backoff.Cap > 0 {
+			delay = min(ra, backoff.Cap)
+		}
+	}
+	return delay
+}
+
+// parseRetryAfter parses the 'Retry-After' header value
+// expressed either in seconds or as an HTTP date.
+func parseRetryAfter(val string) time.Duration {
+	if len(val) == 0 {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(val); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	if at, err := http.ParseTime(val); err == nil {
+		return time.Until(at)
+	}
+
+	return 0
+}
+
+// retryBackoff returns the backoff described by the retry policy,
+// using the defaults for all the unspecified attributes.
+func retryBackoff(policy *v1alpha1.RetryPolicy) wait.Backoff {
+	res := wait.Backoff{
+		Steps:    defaultMaxAttempts,
+		Duration: defaultBackoff,
+		Cap:      defaultMaxBackoff,
+		Factor:   2.0,
+		Jitter:   float64(defaultJitterPercent) / 100,
+	}
+
+	if policy == nil {
+		return res
+	}
+
+	if policy.MaxAttempts > 0 {
+		res.Steps = int(policy.MaxAttempts)
+	}
+	if policy.Backoff != nil {
+		res.Duration = policy.Backoff.Duration
+	}
+	if policy.MaxBackoff != nil {
+		res.Cap = policy.MaxBackoff.Duration
+	}
+	if policy.JitterPercent > 0 {
+		res.Jitter = float64(policy.JitterPercent) / 100
+	}
+
+	return res
+}
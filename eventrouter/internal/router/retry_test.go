@@ -0,0 +1,250 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"github.com/krateoplatformops/eventrouter/internal/deadletter"
+	"github.com/krateoplatformops/eventrouter/internal/helpers/queue"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdvisorRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statusCodes   []int
+		expCalls      int32
+		expDeadLetter bool
+	}{
+		{
+			name:        "Success at first attempt",
+			statusCodes: []int{http.StatusOK},
+			expCalls:    1,
+		},
+		{
+			name:        "Success after retries",
+			statusCodes: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusAccepted},
+			expCalls:    3,
+		},
+		{
+			name:          "Retries exhausted",
+			statusCodes:   []int{http.StatusBadGateway},
+			expCalls:      3,
+			expDeadLetter: true,
+		},
+		{
+			name:          "Not retryable",
+			statusCodes:   []int{http.StatusBadRequest},
+			expCalls:      1,
+			expDeadLetter: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				idx := min(int(n), len(tt.statusCodes)) - 1
+				w.WriteHeader(tt.statusCodes[idx])
+			}))
+			defer srv.Close()
+
+			sink := &mockSink{}
+
+			job := newAdvisor(advOpts{
+				httpClient:       srv.Client(),
+				registrationName: "test",
+				registrationSpec: v1alpha1.RegistrationSpec{
+					ServiceName: "test",
+					Endpoint:    srv.URL,
+					Retry: &v1alpha1.RetryPolicy{
+						MaxAttempts: 3,
+						Backoff:     &metav1.Duration{Duration: time.Millisecond},
+					},
+				},
+				events:      []corev1.Event{{Reason: "Test"}},
+				deadLetters: sink,
+			})
+			job.Job()
+
+			if got := atomic.LoadInt32(&calls); got != tt.expCalls {
+				t.Errorf("calls: got %d, expected %d", got, tt.expCalls)
+			}
+
+			if got := len(sink.all) > 0; got != tt.expDeadLetter {
+				t.Errorf("dead letter: got %v, expected %v", got, tt.expDeadLetter)
+			}
+		})
+	}
+}
+
+func TestDeadLetterAttempts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	sink := &mockSink{}
+
+	// a replayed dead letter failing again
+	job := newAdvisor(advOpts{
+		httpClient:       srv.Client(),
+		registrationName: "test",
+		registrationSpec: v1alpha1.RegistrationSpec{
+			ServiceName: "test",
+			Endpoint:    srv.URL,
+			Retry: &v1alpha1.RetryPolicy{
+				MaxAttempts: 2,
+				Backoff:     &metav1.Duration{Duration: time.Millisecond},
+			},
+		},
+		events:      []corev1.Event{{Reason: "Test"}},
+		deadLetters: sink,
+		attempts:    3,
+	})
+	job.Job()
+
+	if len(sink.all) != 1 || sink.all[0].Attempts != 5 {
+		t.Fatalf("expected a dead letter with the previous attempts, got %+v", sink.all)
+	}
+}
+
+func TestAdvisorRetryInterrupted(t *testing.T) {
+	called := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case called <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	sink := &mockSink{}
+
+	job := newAdvisor(advOpts{
+		httpClient:       srv.Client(),
+		registrationName: "test",
+		registrationSpec: v1alpha1.RegistrationSpec{
+			ServiceName: "test",
+			Endpoint:    srv.URL,
+			Retry: &v1alpha1.RetryPolicy{
+				MaxAttempts: 5,
+				Backoff:     &metav1.Duration{Duration: time.Hour},
+			},
+		},
+		events:      []corev1.Event{{Reason: "Test"}},
+		deadLetters: sink,
+		stats:       newDeliveryStats(),
+	})
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- job.deliver(stop)
+	}()
+
+	<-called
+	close(stop)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected the last delivery error")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the backoff to be interrupted")
+	}
+
+	if len(sink.all) != 1 || sink.all[0].Attempts != 1 {
+		t.Errorf("expected a dead letter after one attempt, got %+v", sink.all)
+	}
+}
+
+func TestAdvisorReject(t *testing.T) {
+	sink := &mockSink{}
+	stats := newDeliveryStats()
+
+	job := newAdvisor(advOpts{
+		registrationName: "test",
+		registrationSpec: v1alpha1.RegistrationSpec{ServiceName: "test", Endpoint: "http://127.0.0.1:9090/handle"},
+		events:           []corev1.Event{{Reason: "Test"}},
+		deadLetters:      sink,
+		stats:            stats,
+	})
+
+	var r queue.Rejecter = job
+	r.Reject(queue.ErrQueueFull)
+
+	// the rejection counts as an attempt
+	if len(sink.all) != 1 || sink.all[0].LastError != queue.ErrQueueFull.Error() || sink.all[0].Attempts != 1 {
+		t.Errorf("expected a dead letter, got %+v", sink.all)
+	}
+	if got := stats.drain()["test"]; got.failed != 1 {
+		t.Errorf("expected a failed delivery, got %+v", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBackoff time.Duration
+		retryAfter time.Duration
+		exp        time.Duration
+	}{
+		{"backoff", time.Minute, 0, time.Second},
+		{"retry after", time.Minute, 5 * time.Second, 5 * time.Second},
+		{"capped retry after", time.Minute, time.Hour, time.Minute},
+		{"no max backoff", 0, 5 * time.Second, 5 * time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backoff := retryBackoff(&v1alpha1.RetryPolicy{
+				Backoff:    &metav1.Duration{Duration: time.Second},
+				MaxBackoff: &metav1.Duration{Duration: tc.maxBackoff},
+			})
+			backoff.Jitter = 0
+
+			err := &deliveryError{statusCode: http.StatusTooManyRequests, retryAfter: tc.retryAfter}
+			if got := retryDelay(&backoff, err); got != tc.exp {
+				t.Errorf("got %v, expected %v", got, tc.exp)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("5"); got != 5*time.Second {
+		t.Errorf("got %v, expected 5s", got)
+	}
+
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("got %v, expected 0", got)
+	}
+
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("got %v, expected 0", got)
+	}
+}
+
+var _ deadletter.Sink = (*mockSink)(nil)
+
+type mockSink struct {
+	mu  sync.Mutex
+	all []deadletter.Entry
+}
+
+func (m *mockSink) Put(e deadletter.Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.all = append(m.all, e)
+	return nil
+}
@@ -1,12 +1,16 @@
 package router
 
 import (
-	"fmt"
+	"sync/atomic"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
@@ -20,70 +24,183 @@ type EventHandler interface {
 // EventRouter is responsible for maintaining a stream of kubernetes
 // system Events and pushing them to another channel for storage
 type EventRouter struct {
-	handler        EventHandler
-	informer       cache.SharedInformer
-	throttlePeriod time.Duration
+	handler          EventHandler
+	informers        *eventInformers
+	namespaces       []string
+	nsWatcher        *namespaceWatcher
+	replay           *replayGate
+	throttlePeriod   time.Duration
+	dedup            *deduper
+	compositionIdKey string
+	// started is set once Run has started the informers,
+	// synced once their initial list has been completed
+	started atomic.Bool
+	synced  atomic.Bool
+
+	watermarkSaveInterval time.Duration
 }
 
+const (
+	defaultWatermarkSaveInterval = 10 * time.Second
+)
+
 type EventRouterOpts struct {
 	RESTClient     rest.Interface
 	Handler        EventHandler
 	ResyncInterval time.Duration
+	// ReplayPolicy tells which events found at startup are
+	// forwarded (default: all).
+	ReplayPolicy ReplayPolicy
+	// ReplayWindow is how old the events forwarded
+	// at startup can be, with the "since" policy.
+	ReplayWindow time.Duration
+	// ThrottlePeriod skips the events older than it at any time, the
+	// updated ones included.
+	//
+	// Deprecated: use ReplayPolicy and ReplayWindow, which only apply
+	// to the events found at startup.
 	ThrottlePeriod time.Duration
-	Namespace      string
+	// Watermark persists the time of the most recent forwarded event,
+	// so that the events already forwarded are skipped after a restart (optional).
+	Watermark WatermarkStore
+	// WatermarkSaveInterval is how often the watermark is saved (default: 10s).
+	WatermarkSaveInterval time.Duration
+	// Source is the API the events are read from (default: core); the
+	// RESTClient must be the one of the corresponding API group.
+	Source EventSource
+	// DedupWindow enables the suppression of the repeated occurrences
+	// of an event: only the first one is forwarded, followed by a summary
+	// every window while the event keeps repeating.
+	DedupWindow time.Duration
+	// CompositionIDKey is the label holding the composition
+	// identifier (default: krateo.io/composition-id).
+	CompositionIDKey string
+	// Namespaces to list and watch, each one with its own
+	// informer; all the namespaces if empty.
+	Namespaces []string
+	// NamespaceSelector restricts the watched namespaces to the ones
+	// matching the selector, re-evaluated as the namespaces change.
+	NamespaceSelector labels.Selector
+	// NamespacesClient is the core/v1 client used to watch the
+	// namespaces; required with a NamespaceSelector.
+	NamespacesClient rest.Interface
+	// FieldSelector restricts the listed events (e.g. type=Warning).
+	FieldSelector fields.Selector
 }
 
 // NewEventRouter will create a new event router using the input params
 func NewEventRouter(opts EventRouterOpts) *EventRouter {
-	lw := cache.NewListWatchFromClient(
-		opts.RESTClient,
-		"events",
-		opts.Namespace, // v1.NamespaceAll,
-		fields.Everything(),
-	)
+	fieldSelector := opts.FieldSelector
+	if fieldSelector == nil {
+		fieldSelector = fields.Everything()
+	}
+
+	res := &EventRouter{
+		handler:          opts.Handler,
+		namespaces:       opts.Namespaces,
+		replay:           newReplayGate(opts.ReplayPolicy, opts.ReplayWindow, opts.Watermark),
+		throttlePeriod:   opts.ThrottlePeriod,
+		compositionIdKey: Enrichment{CompositionIDKey: opts.CompositionIDKey}.compositionIDKey(),
+	}
 
-	si := cache.NewSharedInformer(lw, &corev1.Event{}, opts.ResyncInterval)
+	res.informers = &eventInformers{
+		restClient:     opts.RESTClient,
+		objType:        opts.Source.objectType(),
+		fieldSelector:  fieldSelector,
+		resyncInterval: opts.ResyncInterval,
+		handler:        res,
+		onStart:        res.replay.restarted,
+		items:          map[string]*eventInformer{},
+	}
+
+	if opts.NamespaceSelector != nil {
+		res.nsWatcher = newNamespaceWatcher(opts.NamespacesClient,
+			opts.Namespaces, opts.NamespaceSelector, res.informers)
+	}
 
-	return &EventRouter{
-		informer:       si,
-		handler:        opts.Handler,
-		throttlePeriod: opts.ThrottlePeriod,
+	res.watermarkSaveInterval = opts.WatermarkSaveInterval
+	if res.watermarkSaveInterval <= 0 {
+		res.watermarkSaveInterval = defaultWatermarkSaveInterval
 	}
+
+	if opts.DedupWindow > 0 {
+		res.dedup = newDeduper(opts.DedupWindow, res.forward)
+	}
+
+	return res
 }
 
 // Run starts the EventRouter/Controller.
 func (er *EventRouter) Run(stopCh <-chan struct{}) {
-	er.informer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    er.OnAdd,
-			UpdateFunc: er.OnUpdate,
-			DeleteFunc: er.OnDelete,
-		},
-	)
-
 	defer utilruntime.HandleCrash()
 
-	er.informer.Run(stopCh)
+	// the watermark is loaded before the informers initial list
+	er.replay.start()
+	go wait.Until(er.replay.save, er.watermarkSaveInterval, stopCh)
 
-	// here is where we kick the caches into gear
-	if !cache.WaitForCacheSync(stopCh, er.informer.HasSynced) {
-		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
-		return
+	if er.dedup != nil {
+		go wait.Until(er.dedup.flush, er.dedup.flushInterval(), stopCh)
+	}
+
+	switch {
+	case er.nsWatcher != nil:
+		go er.nsWatcher.run(stopCh)
+	case len(er.namespaces) == 0:
+		er.informers.start(metav1.NamespaceAll)
+	default:
+		for _, ns := range er.namespaces {
+			er.informers.start(ns)
+		}
 	}
+	er.started.Store(true)
 
 	<-stopCh
+	er.informers.stopAll()
+	er.replay.save()
+}
+
+// HasSynced tells whether the events informers have completed the
+// initial list; the informers started later on, as new namespaces
+// match the selector, don't count.
+func (er *EventRouter) HasSynced() bool {
+	if er.synced.Load() {
+		return true
+	}
+
+	if !er.started.Load() {
+		return false
+	}
+	if er.nsWatcher != nil && !er.nsWatcher.hasSynced() {
+		return false
+	}
+	if !er.informers.hasSynced() {
+		return false
+	}
+
+	er.synced.Store(true)
+	return true
 }
 
+var _ cache.ResourceEventHandler = (*EventRouter)(nil)
+
 // OnAdd is called when an event is created, or during the initial list
-func (er *EventRouter) OnAdd(obj interface{}) {
-	event := obj.(*corev1.Event)
-	er.onEvent(event)
+func (er *EventRouter) OnAdd(obj interface{}, isInInitialList bool) {
+	if event, ok := toCoreEvent(obj); ok {
+		er.onEvent(event, isInInitialList)
+	}
 }
 
-// OnUpdate is called any time there is an update to an existing event
+// OnUpdate is called any time there is an update to an existing event;
+// the events redelivered unchanged by the resyncs and relists are skipped.
 func (er *EventRouter) OnUpdate(objOld interface{}, objNew interface{}) {
-	event := objNew.(*corev1.Event)
-	er.onEvent(event)
+	if isResync(objOld, objNew) {
+		informerResyncs.WithLabelValues(informerEvents).Inc()
+		return
+	}
+
+	if event, ok := toCoreEvent(objNew); ok {
+		er.onEvent(event, false)
+	}
 }
 
 // OnDelete should only occur when the system garbage collects events via TTL expiration
@@ -92,20 +209,21 @@ func (er *EventRouter) OnDelete(obj interface{}) {
 		return
 	}
 
-	e := obj.(*corev1.Event)
 	// NOTE: This should *only* happen on TTL expiration there
 	// is no reason to push this to a collector
-	klog.V(6).Infof("Event deleted from the system: %v", e)
+	klog.V(6).Infof("Event deleted from the system: %v", obj)
 }
 
-func (er *EventRouter) onEvent(event *corev1.Event) {
+func (er *EventRouter) onEvent(event *corev1.Event, isInInitialList bool) {
+	eventsReceived.Inc()
+
 	klog.V(4).InfoS("Received event",
 		"msg", event.Message,
 		"namespace", event.Namespace,
 		"reason", event.Reason,
 		"involvedObject", event.InvolvedObject.Name)
 
-	if hasCompositionId(event) {
+	if hasCompositionId(event, er.compositionIdKey) {
 		klog.V(4).InfoS("CompositionID already present",
 			"msg", event.Message,
 			"namespace", event.Namespace,
@@ -114,8 +232,21 @@ func (er *EventRouter) onEvent(event *corev1.Event) {
 		return
 	}
 
-	// It's probably an old event we are catching, it's not the best way but anyways
-	if er.throttlePeriod > 0 && time.Since(event.LastTimestamp.Time) > er.throttlePeriod {
+	if !er.replay.admit(event, isInInitialList) || er.throttled(event) {
+		eventsSkipped.Inc()
+		klog.V(4).InfoS("Event skipped by the replay policy",
+			"namespace", event.Namespace,
+			"reason", event.Reason,
+			"involvedObject", event.InvolvedObject.Name)
+		return
+	}
+
+	if er.dedup != nil && !er.dedup.admit(event) {
+		eventsSuppressed.Inc()
+		klog.V(4).InfoS("Repeated event suppressed",
+			"namespace", event.Namespace,
+			"reason", event.Reason,
+			"involvedObject", event.InvolvedObject.Name)
 		return
 	}
 
@@ -123,5 +254,34 @@ func (er *EventRouter) onEvent(event *corev1.Event) {
 	// 	return
 	// }
 
+	er.forward(event)
+}
+
+// forward hands the event, or the summary of the suppressed
+// ones, to the handler and advances the watermark; the watermark
+// doesn't wait for the delivery, so the events lost by the queue
+// are not replayed after a restart (at-most-once).
+func (er *EventRouter) forward(event *corev1.Event) {
+	er.replay.forwarded(event)
 	er.handler.Handle(*event.DeepCopy())
 }
+
+// throttled tells whether the event is older than the (deprecated)
+// throttle period.
+func (er *EventRouter) throttled(event *corev1.Event) bool {
+	return er.throttlePeriod > 0 && time.Since(eventTimestamp(event)) > er.throttlePeriod
+}
+
+// isResync tells whether the update has been triggered by the
+// informer periodic resync, i.e. the object has not changed.
+func isResync(objOld, objNew interface{}) bool {
+	oldMeta, err := meta.Accessor(objOld)
+	if err != nil {
+		return false
+	}
+	newMeta, err := meta.Accessor(objNew)
+	if err != nil {
+		return false
+	}
+	return oldMeta.GetResourceVersion() == newMeta.GetResourceVersion()
+}
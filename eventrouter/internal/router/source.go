@@ -0,0 +1,113 @@
+package router
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// EventSource is the API the events are read from.
+type EventSource string
+
+const (
+	// EventSourceCore watches the core/v1 events.
+	EventSourceCore EventSource = "core"
+	// EventSourceEvents watches the events.k8s.io/v1 events.
+	EventSourceEvents EventSource = "events"
+)
+
+// ParseEventSource validates the name of an events source.
+func ParseEventSource(s string) (EventSource, error) {
+	switch src := EventSource(s); src {
+	case EventSourceCore, EventSourceEvents:
+		return src, nil
+	case "":
+		return EventSourceCore, nil
+	default:
+		return "", fmt.Errorf("invalid event source %q (valid values: %s, %s)",
+			s, EventSourceCore, EventSourceEvents)
+	}
+}
+
+// objectType returns the type of the objects listed from the source.
+func (s EventSource) objectType() runtime.Object {
+	if s == EventSourceEvents {
+		return &eventsv1.Event{}
+	}
+	return &corev1.Event{}
+}
+
+// toCoreEvent returns the informer object as a core/v1 event.
+func toCoreEvent(obj interface{}) (*corev1.Event, bool) {
+	switch evt := obj.(type) {
+	case *corev1.Event:
+		return evt, true
+	case *eventsv1.Event:
+		return eventFromV1(evt), true
+	default:
+		return nil, false
+	}
+}
+
+// eventFromV1 normalizes an events.k8s.io/v1 event into a core/v1 one:
+// the deprecated timestamps and count, if not set, are computed from
+// the event time and from the series.
+func eventFromV1(in *eventsv1.Event) *corev1.Event {
+	res := &corev1.Event{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Event",
+		},
+		ObjectMeta:          *in.ObjectMeta.DeepCopy(),
+		InvolvedObject:      in.Regarding,
+		Reason:              in.Reason,
+		Message:             in.Note,
+		Source:              in.DeprecatedSource,
+		FirstTimestamp:      in.DeprecatedFirstTimestamp,
+		LastTimestamp:       in.DeprecatedLastTimestamp,
+		Count:               in.DeprecatedCount,
+		Type:                in.Type,
+		EventTime:           in.EventTime,
+		Action:              in.Action,
+		ReportingController: in.ReportingController,
+		ReportingInstance:   in.ReportingInstance,
+	}
+
+	if in.Related != nil {
+		res.Related = in.Related.DeepCopy()
+	}
+
+	if len(res.Source.Component) == 0 {
+		res.Source.Component = in.ReportingController
+	}
+
+	if res.FirstTimestamp.IsZero() && !in.EventTime.IsZero() {
+		res.FirstTimestamp = metav1.NewTime(in.EventTime.Time)
+	}
+
+	if in.Series != nil {
+		res.Series = &corev1.EventSeries{
+			Count:            in.Series.Count,
+			LastObservedTime: in.Series.LastObservedTime,
+		}
+
+		if res.Count == 0 {
+			res.Count = in.Series.Count
+		}
+		if res.LastTimestamp.IsZero() {
+			res.LastTimestamp = metav1.NewTime(in.Series.LastObservedTime.Time)
+		}
+	}
+
+	if res.Count == 0 {
+		res.Count = 1
+	}
+	if res.LastTimestamp.IsZero() {
+		res.LastTimestamp = res.FirstTimestamp
+	}
+
+	return res
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventFromV1(t *testing.T) {
+	first := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+	last := first.Add(5 * time.Minute)
+
+	tests := []struct {
+		name     string
+		series   *eventsv1.EventSeries
+		expCount int32
+		expLast  time.Time
+	}{
+		{"single occurrence", nil, 1, first},
+		{"series", &eventsv1.EventSeries{Count: 12, LastObservedTime: metav1.NewMicroTime(last)}, 12, last},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			in := &eventsv1.Event{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "fake-event-1",
+					Namespace: "demo-system",
+					UID:       "uid-1",
+				},
+				EventTime:           metav1.NewMicroTime(first),
+				Series:              tc.series,
+				ReportingController: "kubelet",
+				ReportingInstance:   "kind-control-plane",
+				Action:              "Pulling",
+				Reason:              "BackOff",
+				Regarding: corev1.ObjectReference{
+					APIVersion: "v1",
+					Kind:       "Pod",
+					Name:       "app-x1",
+				},
+				Note: "Back-off restarting failed container",
+				Type: corev1.EventTypeWarning,
+			}
+
+			got, ok := toCoreEvent(in)
+			if !ok {
+				t.Fatal("expected conversion")
+			}
+
+			if got.InvolvedObject.Name != "app-x1" || got.Message != in.Note {
+				t.Errorf("regarding/note not mapped: %+v", got)
+			}
+			if got.ReportingController != "kubelet" || got.Source.Component != "kubelet" {
+				t.Errorf("reporting controller not mapped: %+v", got)
+			}
+			if got.Count != tc.expCount {
+				t.Errorf("count: got %d, expected %d", got.Count, tc.expCount)
+			}
+			if !got.LastTimestamp.Time.Equal(tc.expLast) {
+				t.Errorf("lastTimestamp: got %v, expected %v", got.LastTimestamp, tc.expLast)
+			}
+			if (got.Series != nil) != (tc.series != nil) {
+				t.Errorf("series: got %v, expected %v", got.Series, tc.series)
+			}
+			if !eventTimestamp(got).Equal(tc.expLast) {
+				t.Errorf("eventTimestamp: got %v, expected %v", eventTimestamp(got), tc.expLast)
+			}
+		})
+	}
+}
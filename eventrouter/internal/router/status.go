@@ -0,0 +1,221 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"github.com/krateoplatformops/eventrouter/internal/objects"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	reasonDelivering     = "Delivering"
+	reasonDeliveryFailed = "DeliveryFailed"
+	reasonSecretRejected = "SecretNamespaceNotAllowed"
+)
+
+// deliveryStat holds the delivery outcomes of a Registration
+// collected since the last status update.
+type deliveryStat struct {
+	delivered           int64
+	failed              int64
+	consecutiveFailures int32
+	lastDelivery        time.Time
+	lastError           string
+	// reason of the Ready condition of the last failure
+	lastReason string
+}
+
+// deliveryStats collects the delivery outcomes of all the Registrations.
+// A nil *deliveryStats discards everything.
+type deliveryStats struct {
+	mu    sync.Mutex
+	items map[string]*deliveryStat
+	// consecutive failures survive the drain
+	failures map[string]int32
+}
+
+func newDeliveryStats() *deliveryStats {
+	return &deliveryStats{
+		items:    map[string]*deliveryStat{},
+		failures: map[string]int32{},
+	}
+}
+
+func (s *deliveryStats) success(name string, n int) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el := s.get(name)
+	el.delivered += int64(n)
+	el.lastDelivery = time.Now()
+	s.failures[name] = 0
+	el.consecutiveFailures = 0
+}
+
+func (s *deliveryStats) failure(name string, n int, err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el := s.get(name)
+	el.failed += int64(n)
+	el.lastError = err.Error()
+	el.lastReason = failureReason(err)
+	s.failures[name]++
+	el.consecutiveFailures = s.failures[name]
+}
+
+// drain returns the collected stats and resets them.
+func (s *deliveryStats) drain() map[string]deliveryStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make(map[string]deliveryStat, len(s.items))
+	for k, v := range s.items {
+		res[k] = *v
+	}
+	s.items = map[string]*deliveryStat{}
+
+	return res
+}
+
+// restore adds back the stats of a failed status update.
+func (s *deliveryStats) restore(name string, delta deliveryStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el := s.get(name)
+	el.delivered += delta.delivered
+	el.failed += delta.failed
+	if el.lastDelivery.Before(delta.lastDelivery) {
+		el.lastDelivery = delta.lastDelivery
+	}
+	if len(el.lastError) == 0 {
+		el.lastError = delta.lastError
+		el.lastReason = delta.lastReason
+	}
+}
+
+// failureReason returns the Ready condition reason of the failure.
+func failureReason(err error) string {
+	if errors.Is(err, errSecretNamespace) {
+		return reasonSecretRejected
+	}
+	return reasonDeliveryFailed
+}
+
+func (s *deliveryStats) get(name string) *deliveryStat {
+	el, ok := s.items[name]
+	if !ok {
+		el = &deliveryStat{consecutiveFailures: s.failures[name]}
+		s.items[name] = el
+	}
+	return el
+}
+
+// nextStatus returns the status obtained adding the collected stats to cur.
+func nextStatus(cur v1alpha1.RegistrationStatus, delta deliveryStat, generation int64) v1alpha1.RegistrationStatus {
+	res := *cur.DeepCopy()
+	res.Delivered += delta.delivered
+	res.Failed += delta.failed
+	res.ConsecutiveFailures = delta.consecutiveFailures
+
+	if !delta.lastDelivery.IsZero() {
+		res.LastDeliveryTime = &metav1.Time{Time: delta.lastDelivery}
+	}
+	if len(delta.lastError) > 0 {
+		res.LastError = delta.lastError
+	}
+
+	cond := metav1.Condition{
+		Type:               v1alpha1.ConditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonDelivering,
+		Message:            "notifications are being delivered",
+		ObservedGeneration: generation,
+	}
+	if res.ConsecutiveFailures > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonDeliveryFailed
+		cond.Message = res.LastError
+		if len(delta.lastReason) > 0 {
+			cond.Reason = delta.lastReason
+		} else if prev := meta.FindStatusCondition(cur.Conditions, v1alpha1.ConditionTypeReady); prev != nil && prev.Status == metav1.ConditionFalse {
+			cond.Reason = prev.Reason
+		}
+	}
+	meta.SetStatusCondition(&res.Conditions, cond)
+
+	return res
+}
+
+// statusUpdater periodically patches the Registrations status
+// with the collected delivery stats.
+type statusUpdater struct {
+	resolver      *objects.ObjectResolver
+	registrations *RegistrationCache
+	stats         *deliveryStats
+	// last written status, the cache may lag behind it
+	written map[string]v1alpha1.RegistrationStatus
+}
+
+func (u *statusUpdater) update() {
+	all := u.registrations.all()
+
+	for name, delta := range u.stats.drain() {
+		reg, ok := all[name]
+		if !ok {
+			delete(u.written, name)
+			continue
+		}
+
+		cur, ok := u.written[name]
+		if !ok {
+			cur = reg.status
+		}
+
+		next := nextStatus(cur, delta, reg.generation)
+
+		dat, err := json.Marshal(map[string]interface{}{
+			"status": next,
+		})
+		if err != nil {
+			klog.ErrorS(err, "unable to encode registration status", "registration", name)
+			continue
+		}
+
+		err = u.resolver.Patch(context.Background(), objects.PatchOpts{
+			PatchData:    dat,
+			GVK:          v1alpha1.RegistrationGroupVersionKind,
+			Name:         name,
+			Subresources: []string{"status"},
+		})
+		if err != nil {
+			klog.ErrorS(err, "unable to patch registration status", "registration", name)
+			u.stats.restore(name, delta)
+			continue
+		}
+
+		u.written[name] = next
+
+		klog.V(4).InfoS("registration status updated",
+			"registration", name,
+			"delivered", next.Delivered,
+			"failed", next.Failed,
+			"consecutiveFailures", next.ConsecutiveFailures)
+	}
+}
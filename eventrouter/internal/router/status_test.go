@@ -0,0 +1,86 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krateoplatformops/eventrouter/apis/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNextStatus(t *testing.T) {
+	stats := newDeliveryStats()
+	stats.success("foo", 1)
+	stats.failure("foo", 1, errors.New("connection refused"))
+	stats.failure("foo", 1, errors.New("connection refused"))
+
+	cur := v1alpha1.RegistrationStatus{
+		Delivered: 10,
+		Failed:    1,
+	}
+
+	next := nextStatus(cur, stats.drain()["foo"], 2)
+	if next.Delivered != 11 {
+		t.Errorf("delivered: got %d, expected 11", next.Delivered)
+	}
+	if next.Failed != 3 {
+		t.Errorf("failed: got %d, expected 3", next.Failed)
+	}
+	if next.ConsecutiveFailures != 2 {
+		t.Errorf("consecutiveFailures: got %d, expected 2", next.ConsecutiveFailures)
+	}
+	if next.LastDeliveryTime == nil {
+		t.Error("lastDeliveryTime: expected not nil")
+	}
+
+	cond := meta.FindStatusCondition(next.Conditions, v1alpha1.ConditionTypeReady)
+	if cond == nil || cond.Status != metav1.ConditionFalse {
+		t.Fatalf("ready condition: got %v, expected status False", cond)
+	}
+	if cond.ObservedGeneration != 2 {
+		t.Errorf("observedGeneration: got %d, expected 2", cond.ObservedGeneration)
+	}
+
+	// consecutive failures are kept across drains until the next success
+	stats.failure("foo", 1, errors.New("connection refused"))
+	next = nextStatus(next, stats.drain()["foo"], 2)
+	if next.ConsecutiveFailures != 3 {
+		t.Errorf("consecutiveFailures: got %d, expected 3", next.ConsecutiveFailures)
+	}
+
+	stats.success("foo", 1)
+	next = nextStatus(next, stats.drain()["foo"], 2)
+	if next.ConsecutiveFailures != 0 {
+		t.Errorf("consecutiveFailures: got %d, expected 0", next.ConsecutiveFailures)
+	}
+	if !meta.IsStatusConditionTrue(next.Conditions, v1alpha1.ConditionTypeReady) {
+		t.Error("ready condition: expected status True")
+	}
+}
+
+func TestNextStatusReason(t *testing.T) {
+	stats := newDeliveryStats()
+	stats.failure("foo", 1, fmt.Errorf("cannot load credentials: %w", errSecretNamespace))
+
+	next := nextStatus(v1alpha1.RegistrationStatus{}, stats.drain()["foo"], 1)
+	cond := meta.FindStatusCondition(next.Conditions, v1alpha1.ConditionTypeReady)
+	if cond == nil || cond.Reason != reasonSecretRejected {
+		t.Fatalf("ready condition: got %v, expected reason %s", cond, reasonSecretRejected)
+	}
+
+	// the reason is kept while the failures carry on without a new one
+	next = nextStatus(next, deliveryStat{consecutiveFailures: 2}, 1)
+	cond = meta.FindStatusCondition(next.Conditions, v1alpha1.ConditionTypeReady)
+	if cond == nil || cond.Reason != reasonSecretRejected {
+		t.Errorf("ready condition: got %v, expected reason %s", cond, reasonSecretRejected)
+	}
+
+	stats.failure("foo", 1, errors.New("connection refused"))
+	next = nextStatus(next, stats.drain()["foo"], 1)
+	cond = meta.FindStatusCondition(next.Conditions, v1alpha1.ConditionTypeReady)
+	if cond == nil || cond.Reason != reasonDeliveryFailed {
+		t.Errorf("ready condition: got %v, expected reason %s", cond, reasonDeliveryFailed)
+	}
+}
@@ -0,0 +1,35 @@
+package router
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// eventTimestamp returns the most recent time at which the event
+// was observed, comparing all the timestamp fields that are set.
+func eventTimestamp(evt *corev1.Event) time.Time {
+	var res time.Time
+
+	if evt.Series != nil && evt.Series.LastObservedTime.Time.After(res) {
+		res = evt.Series.LastObservedTime.Time
+	}
+
+	if evt.LastTimestamp.Time.After(res) {
+		res = evt.LastTimestamp.Time
+	}
+
+	if evt.EventTime.Time.After(res) {
+		res = evt.EventTime.Time
+	}
+
+	if evt.FirstTimestamp.Time.After(res) {
+		res = evt.FirstTimestamp.Time
+	}
+
+	if res.IsZero() {
+		return evt.CreationTimestamp.Time
+	}
+
+	return res
+}
@@ -0,0 +1,244 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// ReplayPolicy tells which events found at startup, i.e. listed
+// by the informers initial sync, are forwarded.
+type ReplayPolicy string
+
+const (
+	// ReplayNone skips all the events present at startup.
+	ReplayNone ReplayPolicy = "none"
+	// ReplaySince forwards the events present at startup
+	// observed within the replay window.
+	ReplaySince ReplayPolicy = "since"
+	// ReplayAll forwards all the events present at startup.
+	ReplayAll ReplayPolicy = "all"
+)
+
+// ParseReplayPolicy validates the name of a replay policy.
+func ParseReplayPolicy(s string) (ReplayPolicy, error) {
+	switch p := ReplayPolicy(s); p {
+	case ReplayNone, ReplaySince, ReplayAll:
+		return p, nil
+	case "":
+		return ReplayAll, nil
+	default:
+		return "", fmt.Errorf("invalid replay policy %q (valid values: %s, %s, %s)",
+			s, ReplayNone, ReplaySince, ReplayAll)
+	}
+}
+
+// WatermarkStore persists the time of the most recent forwarded event.
+type WatermarkStore interface {
+	// Load returns the stored watermark (zero if none).
+	Load() (time.Time, error)
+	Save(t time.Time) error
+}
+
+const (
+	watermarkKey = "watermark"
+)
+
+// NewConfigMapWatermark returns a WatermarkStore backed by a ConfigMap.
+func NewConfigMapWatermark(client corev1client.ConfigMapsGetter, namespace, name string) WatermarkStore {
+	return &configMapWatermark{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+type configMapWatermark struct {
+	client    corev1client.ConfigMapsGetter
+	namespace string
+	name      string
+}
+
+func (w *configMapWatermark) Load() (time.Time, error) {
+	cm, err := w.client.ConfigMaps(w.namespace).Get(context.Background(), w.name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	val, ok := cm.Data[watermarkKey]
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339Nano, val)
+}
+
+func (w *configMapWatermark) Save(t time.Time) error {
+	ctx := context.Background()
+	val := t.UTC().Format(time.RFC3339Nano)
+
+	cm, err := w.client.ConfigMaps(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = w.client.ConfigMaps(w.namespace).Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      w.name,
+				Namespace: w.namespace,
+			},
+			Data: map[string]string{watermarkKey: val},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[watermarkKey] = val
+
+	_, err = w.client.ConfigMaps(w.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// replayGate decides which events of the initial sync are forwarded
+// and keeps track of the most recent forwarded event.
+type replayGate struct {
+	policy ReplayPolicy
+	window time.Duration
+	store  WatermarkStore
+
+	mu        sync.Mutex
+	startedAt time.Time
+	loaded    time.Time
+	latest    time.Time
+	saved     time.Time
+	// seen is the most recent event received from each namespace,
+	// floors are the ones when their informers have been restarted:
+	// the events listed again by those informers are skipped
+	seen   map[string]time.Time
+	floors map[string]time.Time
+}
+
+func newReplayGate(policy ReplayPolicy, window time.Duration, store WatermarkStore) *replayGate {
+	return &replayGate{
+		policy: policy,
+		window: window,
+		store:  store,
+		seen:   map[string]time.Time{},
+		floors: map[string]time.Time{},
+	}
+}
+
+// start loads the stored watermark; it must be called before
+// the informers start.
+func (g *replayGate) start() {
+	var loaded time.Time
+	if g.store != nil {
+		var err error
+		loaded, err = g.store.Load()
+		if err != nil {
+			klog.ErrorS(err, "unable to load the events watermark")
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.startedAt = time.Now()
+	g.loaded, g.latest, g.saved = loaded, loaded, loaded
+
+	klog.InfoS("Replay policy", "policy", g.policy, "window", g.window, "watermark", loaded)
+}
+
+// restarted is called when the events informer of the namespace is
+// (re)started: the events already received from it will be skipped.
+func (g *replayGate) restarted(ns string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ts, ok := g.seen[ns]; ok {
+		g.floors[ns] = ts
+	}
+}
+
+// admit tells whether an event has to be forwarded: the events not
+// listed by the initial sync are always forwarded, the others only if
+// allowed by the policy and more recent than the stored watermark and
+// the ones already received from the namespace.
+func (g *replayGate) admit(evt *corev1.Event, isInInitialList bool) bool {
+	ts := eventTimestamp(evt)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ts.After(g.seen[evt.Namespace]) {
+		g.seen[evt.Namespace] = ts
+	}
+
+	if !isInInitialList {
+		return true
+	}
+
+	if !g.loaded.IsZero() && !ts.After(g.loaded) {
+		return false
+	}
+	if floor, ok := g.floors[evt.Namespace]; ok && !ts.After(floor) {
+		return false
+	}
+
+	switch g.policy {
+	case ReplayNone:
+		return false
+	case ReplaySince:
+		return ts.After(g.startedAt.Add(-g.window))
+	default:
+		return true
+	}
+}
+
+// forwarded moves the watermark forward.
+func (g *replayGate) forwarded(evt *corev1.Event) {
+	ts := eventTimestamp(evt)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ts.After(g.latest) {
+		g.latest = ts
+	}
+}
+
+// save stores the watermark, if it has moved.
+func (g *replayGate) save() {
+	if g.store == nil {
+		return
+	}
+
+	g.mu.Lock()
+	latest, saved := g.latest, g.saved
+	g.mu.Unlock()
+
+	if !latest.After(saved) {
+		return
+	}
+
+	if err := g.store.Save(latest); err != nil {
+		klog.ErrorS(err, "unable to save the events watermark")
+		return
+	}
+
+	g.mu.Lock()
+	g.saved = latest
+	g.mu.Unlock()
+}
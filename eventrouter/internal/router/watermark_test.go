@@ -0,0 +1,271 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEventTimestamp(t *testing.T) {
+	first := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+	last := first.Add(5 * time.Minute)
+
+	tests := []struct {
+		name string
+		evt  corev1.Event
+		exp  time.Time
+	}{
+		{"creation only", corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(first)},
+		}, first},
+		{"first timestamp", corev1.Event{
+			FirstTimestamp: metav1.NewTime(first),
+		}, first},
+		{"last timestamp", corev1.Event{
+			FirstTimestamp: metav1.NewTime(first),
+			LastTimestamp:  metav1.NewTime(last),
+		}, last},
+		{"event time", corev1.Event{
+			EventTime: metav1.NewMicroTime(last),
+		}, last},
+		{"series", corev1.Event{
+			EventTime: metav1.NewMicroTime(first),
+			Series:    &corev1.EventSeries{LastObservedTime: metav1.NewMicroTime(last)},
+		}, last},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := eventTimestamp(&tc.evt); !got.Equal(tc.exp) {
+				t.Errorf("got %v, expected %v", got, tc.exp)
+			}
+		})
+	}
+}
+
+type memWatermark struct {
+	t time.Time
+}
+
+func (m *memWatermark) Load() (time.Time, error) { return m.t, nil }
+
+func (m *memWatermark) Save(t time.Time) error {
+	m.t = t
+	return nil
+}
+
+func TestReplayGate(t *testing.T) {
+	now := time.Now()
+	old := corev1.Event{LastTimestamp: metav1.NewTime(now.Add(-time.Hour))}
+	recent := corev1.Event{LastTimestamp: metav1.NewTime(now.Add(-time.Minute))}
+
+	tests := []struct {
+		policy    ReplayPolicy
+		watermark time.Time
+		expOld    bool
+		expRecent bool
+	}{
+		{ReplayAll, time.Time{}, true, true},
+		{ReplayNone, time.Time{}, false, false},
+		{ReplaySince, time.Time{}, false, true},
+		{ReplayAll, now.Add(-30 * time.Minute), false, true},
+		{ReplayAll, now, false, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			g := newReplayGate(tc.policy, 10*time.Minute, &memWatermark{t: tc.watermark})
+			g.start()
+
+			if got := g.admit(&old, true); got != tc.expOld {
+				t.Errorf("old event: got %v, expected %v", got, tc.expOld)
+			}
+			if got := g.admit(&recent, true); got != tc.expRecent {
+				t.Errorf("recent event: got %v, expected %v", got, tc.expRecent)
+			}
+			if !g.admit(&old, false) {
+				t.Error("events received after the initial list must be admitted")
+			}
+		})
+	}
+}
+
+func TestReplayGateSave(t *testing.T) {
+	store := &memWatermark{}
+	g := newReplayGate(ReplayAll, 0, store)
+	g.start()
+
+	ts := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+	g.forwarded(&corev1.Event{LastTimestamp: metav1.NewTime(ts)})
+	g.forwarded(&corev1.Event{LastTimestamp: metav1.NewTime(ts.Add(-time.Minute))})
+	g.save()
+
+	if !store.t.Equal(ts) {
+		t.Errorf("got %v, expected %v", store.t, ts)
+	}
+}
+
+func TestConfigMapWatermark(t *testing.T) {
+	store := NewConfigMapWatermark(fake.NewClientset().CoreV1(), "krateo-system", "eventrouter-watermark")
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Errorf("got %v, expected zero time", got)
+	}
+
+	ts := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+	for _, el := range []time.Time{ts.Add(-time.Hour), ts} {
+		if err := store.Save(el); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(ts) {
+		t.Errorf("got %v, expected %v", got, ts)
+	}
+}
+
+func TestParseReplayPolicy(t *testing.T) {
+	if got, err := ParseReplayPolicy(""); err != nil || got != ReplayAll {
+		t.Errorf("got %q (%v), expected %q", got, err, ReplayAll)
+	}
+	if _, err := ParseReplayPolicy("latest"); err == nil {
+		t.Error("expected error with an invalid policy")
+	}
+}
+
+type handledEvents []corev1.Event
+
+func (h *handledEvents) Handle(e corev1.Event) { *h = append(*h, e) }
+
+func TestThrottlePeriod(t *testing.T) {
+	var got handledEvents
+	er := &EventRouter{
+		handler:        &got,
+		replay:         newReplayGate(ReplayAll, 0, nil),
+		throttlePeriod: time.Hour,
+	}
+
+	now := time.Now()
+	old := &corev1.Event{
+		ObjectMeta:    metav1.ObjectMeta{Name: "old", ResourceVersion: "1"},
+		LastTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+	}
+	recent := &corev1.Event{
+		ObjectMeta:    metav1.ObjectMeta{Name: "recent", ResourceVersion: "2"},
+		LastTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+	}
+
+	// updated again, e.g. a repeated event
+	update := func(evt *corev1.Event) {
+		next := evt.DeepCopy()
+		next.ResourceVersion += "0"
+		er.OnUpdate(evt, next)
+	}
+	update(old)
+	update(recent)
+
+	if len(got) != 1 || got[0].Name != "recent" {
+		t.Errorf("handled: got %v, expected the recent event only", got)
+	}
+}
+
+func TestOnUpdateResync(t *testing.T) {
+	var got handledEvents
+	er := &EventRouter{
+		handler: &got,
+		replay:  newReplayGate(ReplayAll, 0, nil),
+	}
+
+	evt := &corev1.Event{
+		ObjectMeta:    metav1.ObjectMeta{Name: "demo", ResourceVersion: "1"},
+		LastTimestamp: metav1.NewTime(time.Now()),
+	}
+
+	// redelivered unchanged by the resync
+	er.OnUpdate(evt, evt.DeepCopy())
+	if len(got) != 0 {
+		t.Fatalf("handled: got %v, expected no events", got)
+	}
+
+	next := evt.DeepCopy()
+	next.ResourceVersion = "2"
+	er.OnUpdate(evt, next)
+	if len(got) != 1 {
+		t.Errorf("handled: got %v, expected the updated event", got)
+	}
+}
+
+type droppedEvents struct{}
+
+func (droppedEvents) Handle(corev1.Event) {}
+
+func TestWatermarkAtMostOnce(t *testing.T) {
+	store := &memWatermark{}
+	er := &EventRouter{
+		handler: droppedEvents{},
+		replay:  newReplayGate(ReplayAll, 0, store),
+	}
+	er.replay.start()
+
+	evt := &corev1.Event{
+		ObjectMeta:    metav1.ObjectMeta{Name: "demo", ResourceVersion: "1"},
+		LastTimestamp: metav1.NewTime(time.Now().Add(-time.Minute)),
+	}
+
+	// forwarded, but dropped before being delivered
+	er.OnAdd(evt, false)
+	er.replay.save()
+
+	// the event is not replayed after a restart
+	g := newReplayGate(ReplayAll, 0, store)
+	g.start()
+	if g.admit(evt, true) {
+		t.Error("expected the forwarded event to be skipped after a restart")
+	}
+}
+
+func TestReplayGateRestarted(t *testing.T) {
+	g := newReplayGate(ReplayAll, 0, nil)
+	g.start()
+
+	ts := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+	event := func(ns string, ts time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:    metav1.ObjectMeta{Namespace: ns},
+			LastTimestamp: metav1.NewTime(ts),
+		}
+	}
+
+	if !g.admit(event("demo", ts), false) {
+		t.Fatal("expected the event to be admitted")
+	}
+	g.restarted("demo")
+	g.restarted("other")
+
+	tests := []struct {
+		evt *corev1.Event
+		exp bool
+	}{
+		{event("demo", ts.Add(-time.Minute)), false},
+		{event("demo", ts), false},
+		{event("demo", ts.Add(time.Minute)), true},
+		{event("other", ts.Add(-time.Minute)), true},
+	}
+
+	for i, tc := range tests {
+		if got := g.admit(tc.evt, true); got != tc.exp {
+			t.Errorf("[%d] got %v, expected %v", i, got, tc.exp)
+		}
+	}
+}
@@ -1,21 +1,33 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/krateoplatformops/eventrouter/internal/deadletter"
 	"github.com/krateoplatformops/eventrouter/internal/env"
+	"github.com/krateoplatformops/eventrouter/internal/health"
 	httputil "github.com/krateoplatformops/eventrouter/internal/helpers/http"
 	"github.com/krateoplatformops/eventrouter/internal/helpers/queue"
+	"github.com/krateoplatformops/eventrouter/internal/leader"
 	"github.com/krateoplatformops/eventrouter/internal/router"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 )
@@ -39,13 +51,79 @@ func main() {
 	resyncInterval := flag.Duration("resync-interval",
 		env.Duration("EVENT_ROUTER_RESYNC_INTERVAL", time.Minute*3), "resync interval")
 	throttlePeriod := flag.Duration("throttle-period",
-		env.Duration("EVENT_ROUTER_THROTTLE_PERIOD", 0), "throttle period")
+		env.Duration("EVENT_ROUTER_THROTTLE_PERIOD", 0), "deprecated: use --replay-policy=since and --replay-window")
+	replayPolicy := flag.String("replay-policy",
+		env.String("EVENT_ROUTER_REPLAY_POLICY", string(router.ReplayAll)), "events found at startup to forward: 'none', 'since' (within --replay-window) or 'all'")
+	replayWindow := flag.Duration("replay-window",
+		env.Duration("EVENT_ROUTER_REPLAY_WINDOW", 5*time.Minute), "how old the events forwarded at startup can be with --replay-policy=since")
+	watermarkConfigMap := flag.String("watermark-configmap",
+		env.String("EVENT_ROUTER_WATERMARK_CONFIGMAP", ""), "namespace/name of the ConfigMap storing the time of the last forwarded event (disabled if empty)")
+	dedupWindow := flag.Duration("dedup-window",
+		env.Duration("EVENT_ROUTER_DEDUP_WINDOW", 0), "window in which the repeated occurrences of an event are summarized (disabled if zero)")
+	eventSource := flag.String("event-source",
+		env.String("EVENT_ROUTER_EVENT_SOURCE", string(router.EventSourceCore)), "events API to watch: 'core' (v1) or 'events' (events.k8s.io/v1)")
 	namespace := flag.String("namespace",
-		env.String("EVENT_ROUTER_NAMESPACE", ""), "namespace to list and watch")
+		env.String("EVENT_ROUTER_NAMESPACE", ""), "comma separated namespaces to list and watch (all if empty)")
+	namespaceSelector := flag.String("namespace-selector",
+		env.String("EVENT_ROUTER_NAMESPACE_SELECTOR", ""), "label selector of the namespaces to list and watch")
+	fieldSelector := flag.String("field-selector",
+		env.String("EVENT_ROUTER_FIELD_SELECTOR", ""), "field selector of the events to list and watch (e.g. type=Warning)")
 	queueMaxCapacity := flag.Int("queue-max-capacity",
 		env.Int("EVENT_ROUTER_QUEUE_MAX_CAPACITY", 10), "notification queue buffer size")
 	queueWorkerThreads := flag.Int("queue-worker-threads",
 		env.Int("EVENT_ROUTER_QUEUE_WORKER_THREADS", 50), "number of worker threads in the notification queue")
+	queueOverflow := flag.String("queue-overflow",
+		env.String("EVENT_ROUTER_QUEUE_OVERFLOW", string(queue.OverflowBlock)), "what to do when the notification queue is full: 'block', 'drop-oldest', 'drop-newest' or 'spill' (to --queue-spill-dir)")
+	queueBlockTimeout := flag.Duration("queue-block-timeout",
+		env.Duration("EVENT_ROUTER_QUEUE_BLOCK_TIMEOUT", 0), "how long the events wait for room in the full queue before being dropped, with --queue-overflow=block (forever if zero)")
+	queueSpillDir := flag.String("queue-spill-dir",
+		env.String("EVENT_ROUTER_QUEUE_SPILL_DIR", ""), "directory where the notifications past the queue capacity are persisted, with --queue-overflow=spill")
+	registrationQueueCapacity := flag.Int("registration-queue-capacity",
+		env.Int("EVENT_ROUTER_REGISTRATION_QUEUE_CAPACITY", 100), "notifications buffered for each registration, besides the ones being delivered")
+	secretNamespaces := flag.String("secret-namespaces",
+		env.String("EVENT_ROUTER_SECRET_NAMESPACES", ""), "comma separated namespaces of the secrets the registrations can reference, '*' for any (default: the pod namespace)")
+	queueDir := flag.String("queue-dir",
+		env.String("EVENT_ROUTER_QUEUE_DIR", ""), "directory where the pending notifications are persisted across restarts (in memory if empty)")
+	queueMaxBytes := flag.Int("queue-max-bytes",
+		env.Int("EVENT_ROUTER_QUEUE_MAX_BYTES", 64<<20), "maximum size in bytes of the pending notifications persisted in --queue-dir or --queue-spill-dir")
+	queueMaxBytesTimeout := flag.Duration("queue-max-bytes-timeout",
+		env.Duration("EVENT_ROUTER_QUEUE_MAX_BYTES_TIMEOUT", 10*time.Second), "how long the events wait for room once --queue-max-bytes is reached before being dropped")
+	statusUpdateInterval := flag.Duration("status-update-interval",
+		env.Duration("EVENT_ROUTER_STATUS_UPDATE_INTERVAL", 30*time.Second), "how often registrations status is updated (disabled if zero)")
+	deadLetterDir := flag.String("dead-letter-dir",
+		env.String("EVENT_ROUTER_DEAD_LETTER_DIR", ""), "directory where undelivered notifications are stored (disabled if empty)")
+	deadLetterReplayInterval := flag.Duration("dead-letter-replay-interval",
+		env.Duration("EVENT_ROUTER_DEAD_LETTER_REPLAY_INTERVAL", 0), "how often undelivered notifications are replayed (disabled if zero)")
+	deadLetterMaxAttempts := flag.Int("dead-letter-max-attempts",
+		env.Int("EVENT_ROUTER_DEAD_LETTER_MAX_ATTEMPTS", 20), "delivery attempts, replays included, after which undelivered notifications are no longer replayed (no limit if zero)")
+	ownerMaxDepth := flag.Int("owner-max-depth",
+		env.Int("EVENT_ROUTER_OWNER_MAX_DEPTH", 5), "how many ownerReferences levels are followed looking for the composition id (disabled if zero)")
+	compositionCacheSize := flag.Int("composition-cache-size",
+		env.Int("EVENT_ROUTER_COMPOSITION_CACHE_SIZE", 10000), "maximum number of cached composition ids (disabled if zero)")
+	compositionCacheTTL := flag.Duration("composition-cache-ttl",
+		env.Duration("EVENT_ROUTER_COMPOSITION_CACHE_TTL", 10*time.Minute), "how long a resolved composition id is cached")
+	compositionIdLabel := flag.String("composition-id-label",
+		env.String("EVENT_ROUTER_COMPOSITION_ID_LABEL", router.DefaultCompositionIDKey), "label holding the composition id")
+	enrichLabels := flag.String("enrich-labels",
+		env.String("EVENT_ROUTER_ENRICH_LABELS", ""), "comma separated label keys copied from the involved object onto the events")
+	enrichAnnotations := flag.String("enrich-annotations",
+		env.String("EVENT_ROUTER_ENRICH_ANNOTATIONS", ""), "comma separated annotation keys copied from the involved object onto the events")
+	httpAddr := flag.String("http-addr",
+		env.String("EVENT_ROUTER_HTTP_ADDR", ":8080"), "address of the HTTP server exposing the /metrics, /healthz and /readyz endpoints (disabled if empty)")
+	livenessTimeout := flag.Duration("liveness-timeout",
+		env.Duration("EVENT_ROUTER_LIVENESS_TIMEOUT", 5*time.Minute), "how long queued notifications can wait for a worker before the service is reported unhealthy")
+	leaderElect := flag.Bool("leader-elect",
+		env.Bool("EVENT_ROUTER_LEADER_ELECT", false), "enable leader election to run multiple replicas")
+	leaderElectionLeaseName := flag.String("leader-election-lease-name",
+		env.String("EVENT_ROUTER_LEADER_ELECTION_LEASE_NAME", "eventrouter"), "name of the leader election lease")
+	leaderElectionNamespace := flag.String("leader-election-namespace",
+		env.String("EVENT_ROUTER_LEADER_ELECTION_NAMESPACE", ""), "namespace of the leader election lease (default: the pod namespace)")
+	leaderElectionLeaseDuration := flag.Duration("leader-election-lease-duration",
+		env.Duration("EVENT_ROUTER_LEADER_ELECTION_LEASE_DURATION", 15*time.Second), "how long standbys wait before taking over a lease that is not renewed")
+	leaderElectionRenewDeadline := flag.Duration("leader-election-renew-deadline",
+		env.Duration("EVENT_ROUTER_LEADER_ELECTION_RENEW_DEADLINE", 10*time.Second), "how long the leader retries renewing the lease before giving up")
+	leaderElectionRetryPeriod := flag.Duration("leader-election-retry-period",
+		env.Duration("EVENT_ROUTER_LEADER_ELECTION_RETRY_PERIOD", 2*time.Second), "how often candidates try to acquire or renew the lease")
 
 	flag.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), "Flags:")
@@ -76,39 +154,214 @@ func main() {
 		}
 	}
 
+	source, err := router.ParseEventSource(*eventSource)
+	if err != nil {
+		klog.Fatalf("unable to parse the event source: %s", err.Error())
+	}
+
+	policy, err := router.ParseReplayPolicy(*replayPolicy)
+	if err != nil {
+		klog.Fatalf("unable to parse the replay policy: %s", err.Error())
+	}
+
+	if *throttlePeriod > 0 {
+		klog.Warningf("--throttle-period is deprecated, use --replay-policy=%s --replay-window=%s instead",
+			router.ReplaySince, *throttlePeriod)
+		policy, *replayWindow = router.ReplaySince, *throttlePeriod
+	}
+
+	var nsSelector labels.Selector
+	if len(*namespaceSelector) > 0 {
+		nsSelector, err = labels.Parse(*namespaceSelector)
+		if err != nil {
+			klog.Fatalf("unable to parse the namespace selector: %s", err.Error())
+		}
+	}
+
+	evtSelector, err := fields.ParseSelector(*fieldSelector)
+	if err != nil {
+		klog.Fatalf("unable to parse the field selector: %s", err.Error())
+	}
+
 	// creates the clientset from kubeconfig
 	clientSet, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		klog.Fatalf("unable to create kubernetes clientset: %s", err.Error())
 	}
 
+	var watermark router.WatermarkStore
+	if len(*watermarkConfigMap) > 0 {
+		ns, name, ok := strings.Cut(*watermarkConfigMap, "/")
+		if !ok || len(ns) == 0 || len(name) == 0 {
+			klog.Fatalf("invalid watermark configmap %q: expected namespace/name", *watermarkConfigMap)
+		}
+		watermark = router.NewConfigMapWatermark(clientSet.CoreV1(), ns, name)
+	}
+
+	registrations, err := router.NewRegistrationCache(router.RegistrationCacheOpts{
+		RESTConfig:     cfg,
+		ResyncInterval: *resyncInterval,
+	})
+	if err != nil {
+		klog.Fatalf("unable to create the registration cache: %s", err.Error())
+	}
+
+	var deadLetters deadletter.Store
+	if len(*deadLetterDir) > 0 {
+		deadLetters, err = deadletter.NewFileStore(*deadLetterDir)
+		if err != nil {
+			klog.Fatalf("unable to create the dead letter store: %s", err.Error())
+		}
+	}
+
 	// setup notification worker queue
-	q := queue.NewQueue(*queueMaxCapacity, *queueWorkerThreads)
+	var q notifyQueue
+	if len(*queueDir) > 0 {
+		q, err = queue.NewDiskQueue(queue.DiskQueueOpts{
+			Dir:        *queueDir,
+			MaxBytes:   int64(*queueMaxBytes),
+			Timeout:    *queueMaxBytesTimeout,
+			OnDrop:     newDropHandler(queue.OverflowBlock),
+			MaxWorkers: *queueWorkerThreads,
+			ErrorHandler: func(err error) {
+				klog.ErrorS(err, "notification queue")
+			},
+		})
+		if err != nil {
+			klog.Fatalf("unable to create the notification queue: %s", err.Error())
+		}
+	} else {
+		overflow, err := newOverflow(*queueOverflow, *queueBlockTimeout, *queueSpillDir,
+			int64(*queueMaxBytes), *queueMaxBytesTimeout, *queueWorkerThreads)
+		if err != nil {
+			klog.Fatalf("unable to configure the queue overflow: %s", err.Error())
+		}
+		q = queue.NewQueueWithOverflow(*queueMaxCapacity, *queueWorkerThreads, overflow)
+	}
+	// terminated by the handler Stop: os.Exit skips the deferred calls
 	q.Run()
-	defer q.Terminate()
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "eventrouter_queue_depth",
+		Help: "Number of notifications waiting in the queue.",
+	}, func() float64 { return float64(q.GetJobCount()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "eventrouter_queue_workers",
+		Help: "Number of queue worker threads.",
+	}, func() float64 { return float64(q.GetMaxWorkers()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "eventrouter_queue_busy_workers",
+		Help: "Number of queue worker threads delivering a notification.",
+	}, func() float64 { return float64(q.GetBusyWorkers()) })
+	if dq, ok := q.(*queue.DiskQueue); ok {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "eventrouter_queue_bytes",
+			Help: "Size in bytes of the persisted notifications not yet delivered.",
+		}, func() float64 { return float64(dq.GetBytes()) })
+	}
+
+	allowedSecrets := splitList(*secretNamespaces)
+	if len(allowedSecrets) == 0 {
+		ns, err := leader.PodNamespace()
+		if err != nil {
+			klog.Warningf("unable to detect the pod namespace, the registrations secrets are rejected: %s", err.Error())
+		} else {
+			allowedSecrets = []string{ns}
+		}
+	}
 
 	handler, err := router.NewPusher(router.PusherOpts{
-		RESTConfig: cfg,
-		Queue:      q,
-		Verbose:    *debug,
-		Insecure:   *insecure,
+		RESTConfig:    cfg,
+		Registrations: registrations,
+		Queue:         q,
+		Verbose:       *debug,
+		Insecure:      *insecure,
+
+		OwnerMaxDepth: *ownerMaxDepth,
+		Enrichment: router.Enrichment{
+			CompositionIDKey: *compositionIdLabel,
+			Labels:           splitList(*enrichLabels),
+			Annotations:      splitList(*enrichAnnotations),
+		},
+		CompositionCacheSize: *compositionCacheSize,
+		CompositionCacheTTL:  *compositionCacheTTL,
+
+		LaneCapacity:     *registrationQueueCapacity,
+		SecretNamespaces: allowedSecrets,
+
+		DeadLetters:              deadLetters,
+		DeadLetterReplayInterval: *deadLetterReplayInterval,
+		DeadLetterMaxAttempts:    *deadLetterMaxAttempts,
+		StatusUpdateInterval:     *statusUpdateInterval,
 	})
 	if err != nil {
 		klog.Fatalf("unable to create the event notifier: %s", err.Error())
 	}
 
+	eventsClient := clientSet.CoreV1().RESTClient()
+	if source == router.EventSourceEvents {
+		eventsClient = clientSet.EventsV1().RESTClient()
+	}
+
 	eventRouter := router.NewEventRouter(router.EventRouterOpts{
-		RESTClient:     clientSet.CoreV1().RESTClient(),
-		Handler:        handler,
-		Namespace:      *namespace,
+		RESTClient:  eventsClient,
+		Source:      source,
+		Handler:     handler,
+		DedupWindow: *dedupWindow,
+
+		ReplayPolicy:   policy,
+		ReplayWindow:   *replayWindow,
 		ThrottlePeriod: *throttlePeriod,
+		Watermark:      watermark,
+
+		Namespaces:        splitList(*namespace),
+		NamespaceSelector: nsSelector,
+		NamespacesClient:  clientSet.CoreV1().RESTClient(),
+		FieldSelector:     evtSelector,
+
+		CompositionIDKey: *compositionIdLabel,
 	})
 
 	stop := sigHandler()
 
+	// standbys don't run the events informer
+	var leading atomic.Bool
+	leading.Store(!*leaderElect)
+
+	if len(*httpAddr) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", promhttp.Handler())
+		mux.Handle("GET /healthz", health.Handler(map[string]health.Check{
+			"dispatcher": health.Progress(q, *livenessTimeout),
+			"lanes":      health.ProgressAll(handler.Lanes, *livenessTimeout),
+		}))
+		mux.Handle("GET /readyz", health.Handler(map[string]health.Check{
+			"registrations": health.Synced(registrations.HasSynced),
+			"events": health.Synced(func() bool {
+				return !leading.Load() || eventRouter.HasSynced()
+			}),
+		}))
+
+		go func() {
+			if err := http.ListenAndServe(*httpAddr, mux); err != nil {
+				klog.Fatalf("unable to start the HTTP server: %s", err.Error())
+			}
+		}()
+	}
+
 	// Startup the EventRouter
 	var wg sync.WaitGroup
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		registrations.Run(stop)
+	}()
+
+	if !cache.WaitForCacheSync(stop, registrations.HasSynced) {
+		klog.Fatalf("timed out waiting for registration cache to sync")
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -116,12 +369,80 @@ func main() {
 		klog.InfoS(fmt.Sprintf("Starting %s", serviceName),
 			"debug", *debug,
 			"resyncInterval", *resyncInterval,
-			"throttlePeriod", *throttlePeriod,
+			"eventSource", source,
+			"replayPolicy", policy,
+			"replayWindow", *replayWindow,
+			"watermarkConfigMap", *watermarkConfigMap,
+			"dedupWindow", *dedupWindow,
 			"namespace", *namespace,
+			"namespaceSelector", *namespaceSelector,
+			"fieldSelector", *fieldSelector,
 			"queueMaxCapacity", *queueMaxCapacity,
-			"queueWorkerThreads", *queueWorkerThreads)
+			"queueWorkerThreads", *queueWorkerThreads,
+			"registrationQueueCapacity", *registrationQueueCapacity,
+			"secretNamespaces", allowedSecrets,
+			"queueOverflow", *queueOverflow,
+			"queueBlockTimeout", *queueBlockTimeout,
+			"queueSpillDir", *queueSpillDir,
+			"queueDir", *queueDir,
+			"queueMaxBytes", *queueMaxBytes,
+			"queueMaxBytesTimeout", *queueMaxBytesTimeout,
+			"statusUpdateInterval", *statusUpdateInterval,
+			"deadLetterDir", *deadLetterDir,
+			"deadLetterReplayInterval", *deadLetterReplayInterval,
+			"deadLetterMaxAttempts", *deadLetterMaxAttempts,
+			"ownerMaxDepth", *ownerMaxDepth,
+			"compositionIdLabel", *compositionIdLabel,
+			"enrichLabels", *enrichLabels,
+			"enrichAnnotations", *enrichAnnotations,
+			"compositionCacheSize", *compositionCacheSize,
+			"compositionCacheTTL", *compositionCacheTTL,
+			"httpAddr", *httpAddr,
+			"livenessTimeout", *livenessTimeout,
+			"leaderElect", *leaderElect)
 
-		eventRouter.Run(stop)
+		if !*leaderElect {
+			if err := handler.Start(stop); err != nil {
+				klog.Fatalf("unable to start the event notifier: %s", err.Error())
+			}
+			eventRouter.Run(stop)
+			handler.Stop()
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-stop
+			cancel()
+		}()
+
+		// standbys keep the registration cache in sync, the events
+		// informer is started only by the leader: its initial list
+		// covers the events emitted while the lease was changing hands.
+		// The leader alone restores the pending notifications, replays
+		// the dead letters and updates the Registrations status.
+		err := leader.Run(ctx, leader.ElectorOpts{
+			Client:         clientSet,
+			LeaseName:      *leaderElectionLeaseName,
+			LeaseNamespace: *leaderElectionNamespace,
+			LeaseDuration:  *leaderElectionLeaseDuration,
+			RenewDeadline:  *leaderElectionRenewDeadline,
+			RetryPeriod:    *leaderElectionRetryPeriod,
+		}, func(ctx context.Context) {
+			leading.Store(true)
+			if err := handler.Start(ctx.Done()); err != nil {
+				klog.Fatalf("unable to start the event notifier: %s", err.Error())
+			}
+			eventRouter.Run(ctx.Done())
+			handler.Stop()
+		})
+		if err != nil {
+			klog.Fatalf("unable to run leader election: %s", err.Error())
+		}
+
+		if ctx.Err() == nil {
+			klog.Fatalf("leader election lost")
+		}
 	}()
 
 	wg.Wait()
@@ -129,6 +450,83 @@ func main() {
 	os.Exit(1)
 }
 
+// notifyQueue is the notification queue, in memory or disk-backed
+type notifyQueue interface {
+	queue.Queuer
+	GetJobCount() int
+	GetBusyWorkers() int
+	GetMaxWorkers() int
+	GetLastDispatch() time.Time
+}
+
+// newOverflow returns the behavior of the full notification queue;
+// each dropped notification is logged, counted and rejected, so that
+// it's stored as a dead letter
+func newOverflow(policy string, timeout time.Duration, spillDir string,
+	maxBytes int64, maxBytesTimeout time.Duration, workers int) (queue.Overflow, error) {
+	p, err := queue.ParseOverflowPolicy(policy)
+	if err != nil {
+		return queue.Overflow{}, err
+	}
+
+	res := queue.Overflow{
+		Policy:  p,
+		Timeout: timeout,
+		OnDrop:  newDropHandler(p),
+	}
+
+	if p == queue.OverflowSpill {
+		if len(spillDir) == 0 {
+			return queue.Overflow{}, fmt.Errorf("the %s policy requires a spill directory", p)
+		}
+
+		res.Spill, err = queue.NewDiskQueue(queue.DiskQueueOpts{
+			Dir:        spillDir,
+			MaxBytes:   maxBytes,
+			Timeout:    maxBytesTimeout,
+			OnDrop:     res.OnDrop,
+			MaxWorkers: workers,
+			ErrorHandler: func(err error) {
+				klog.ErrorS(err, "notification spill queue")
+			},
+		})
+		if err != nil {
+			return queue.Overflow{}, err
+		}
+	}
+
+	return res, nil
+}
+
+// newDropHandler returns the function counting, logging and
+// rejecting the notifications dropped because the queue is full.
+func newDropHandler(p queue.OverflowPolicy) func(job queue.Jober) {
+	dropped := promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventrouter_queue_dropped_total",
+		Help: "Number of notifications dropped because the queue was full.",
+	}, []string{"policy"})
+
+	return func(job queue.Jober) {
+		dropped.WithLabelValues(string(p)).Inc()
+		klog.InfoS("notification dropped, the queue is full", "policy", p)
+
+		if r, ok := job.(queue.Rejecter); ok {
+			r.Reject(queue.ErrQueueFull)
+		}
+	}
+}
+
+// splitList returns the non empty items of a comma separated list
+func splitList(s string) []string {
+	var res []string
+	for _, el := range strings.Split(s, ",") {
+		if el = strings.TrimSpace(el); len(el) > 0 {
+			res = append(res, el)
+		}
+	}
+	return res
+}
+
 // setup a signal hander to gracefully exit
 func sigHandler() <-chan struct{} {
 	stop := make(chan struct{})
@@ -0,0 +1,107 @@
+package pub
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/krateoplatformops/eventsse/internal/store"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// filter selects the events sent to a SSE client; each field
+// accepts more values (repeated or comma separated parameters),
+// an empty field matches any event.
+type filter struct {
+	composition string
+	namespaces  []string
+	kinds       []string
+	types       []string
+	reasons     []string
+}
+
+// filterFromQuery reads the filter from the request query parameters:
+// composition, namespace, kind, type and reason.
+func filterFromQuery(q url.Values) (filter, error) {
+	res := filter{
+		composition: strings.TrimSpace(q.Get("composition")),
+		namespaces:  queryValues(q, "namespace"),
+		kinds:       queryValues(q, "kind"),
+		types:       queryValues(q, "type"),
+		reasons:     queryValues(q, "reason"),
+	}
+
+	if err := validateComposition(res.composition); err != nil {
+		return res, err
+	}
+
+	for _, el := range res.types {
+		if !strings.EqualFold(el, corev1.EventTypeNormal) && !strings.EqualFold(el, corev1.EventTypeWarning) {
+			return res, fmt.Errorf("invalid event type %q (expected %s or %s)",
+				el, corev1.EventTypeNormal, corev1.EventTypeWarning)
+		}
+	}
+
+	return res, nil
+}
+
+// validateComposition rejects the composition identifiers that
+// would move the watched prefix out of the composition keys.
+func validateComposition(id string) error {
+	if strings.Contains(id, "/") || strings.Contains(id, "..") {
+		return fmt.Errorf("invalid composition %q", id)
+	}
+	return nil
+}
+
+// prefix is the etcd key prefix to watch: a composition
+// narrows the watch to the keys of its events only.
+func (f *filter) prefix() string {
+	if len(f.composition) == 0 {
+		return store.RootKey
+	}
+
+	// the trailing slash excludes the compositions whose
+	// identifier starts with the requested one
+	return store.PrepareKey("", f.composition) + "/"
+}
+
+// match reports whether the event satisfies the in-process filters;
+// the composition is already selected by the watched prefix.
+func (f *filter) match(obj *corev1.Event) bool {
+	ns := obj.InvolvedObject.Namespace
+	if len(ns) == 0 {
+		ns = obj.Namespace
+	}
+
+	return matchAny(f.namespaces, ns, false) &&
+		matchAny(f.kinds, obj.InvolvedObject.Kind, true) &&
+		matchAny(f.types, obj.Type, true) &&
+		matchAny(f.reasons, obj.Reason, false)
+}
+
+func matchAny(all []string, val string, fold bool) bool {
+	if len(all) == 0 {
+		return true
+	}
+
+	for _, el := range all {
+		if el == val || (fold && strings.EqualFold(el, val)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func queryValues(q url.Values, key string) []string {
+	var res []string
+	for _, el := range q[key] {
+		for _, x := range strings.Split(el, ",") {
+			if x = strings.TrimSpace(x); len(x) > 0 {
+				res = append(res, x)
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,75 @@
+package pub
+
+import (
+	"net/url"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFilterMatch(t *testing.T) {
+	evt := corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "lorem.123",
+			Namespace: "demo-system",
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Deployment",
+			Namespace: "demo-system",
+		},
+		Type:   corev1.EventTypeWarning,
+		Reason: "FailedCreate",
+	}
+
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"", true},
+		{"namespace=demo-system", true},
+		{"namespace=default", false},
+		{"namespace=default,demo-system", true},
+		{"namespace=default&namespace=demo-system", true},
+		{"kind=deployment", true},
+		{"kind=Pod", false},
+		{"type=Warning", true},
+		{"type=normal", false},
+		{"reason=FailedCreate", true},
+		{"reason=failedcreate", false},
+		{"kind=Deployment&reason=Scheduled", false},
+	}
+
+	for _, tc := range tests {
+		q, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		flt, err := filterFromQuery(q)
+		if err != nil {
+			t.Fatalf("%q: %s", tc.query, err)
+		}
+
+		if got := flt.match(&evt); got != tc.expected {
+			t.Errorf("%q: got %v, expected %v", tc.query, got, tc.expected)
+		}
+	}
+}
+
+func TestFilterPrefix(t *testing.T) {
+	tests := []struct {
+		composition string
+		expected    string
+	}{
+		{"", "krateo.io.events"},
+		{"ABC-123", "krateo.io.events/comp-abc-123/"},
+	}
+
+	for _, tc := range tests {
+		flt := filter{composition: tc.composition}
+		if got := flt.prefix(); got != tc.expected {
+			t.Errorf("%q: got %q, expected %q", tc.composition, got, tc.expected)
+		}
+	}
+}
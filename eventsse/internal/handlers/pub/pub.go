@@ -1,29 +1,85 @@
 package pub
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/krateoplatformops/eventsse/internal/hub"
 	"github.com/krateoplatformops/eventsse/internal/labels"
-	"github.com/krateoplatformops/eventsse/internal/store"
 	"github.com/rs/zerolog"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	corev1 "k8s.io/api/core/v1"
 )
 
-func SSE(cli clientv3.Watcher) http.Handler {
+const (
+	// replayTimeout is the timeout of the Gets replaying the stored
+	// events when the resume revision has been compacted.
+	replayTimeout = 5 * time.Second
+	// replayPageSize is the number of events read by each of them.
+	replayPageSize = 500
+)
+
+// Client is the etcd client used to resume the streams: the missed
+// events are watched from the client last one, the stored ones are
+// read when that revision has been compacted.
+type Client interface {
+	clientv3.Watcher
+	clientv3.KV
+}
+
+// SSEOptions configures the /notifications stream.
+type SSEOptions struct {
+	// Hub delivers the live events.
+	Hub *hub.Hub
+	// Client watches the events missed by a client resuming the stream.
+	Client Client
+	// Heartbeat is how often a comment is sent while there
+	// are no events; zero disables the heartbeats.
+	Heartbeat time.Duration
+	// Retry is the reconnection delay suggested to the clients;
+	// zero leaves the client default.
+	Retry time.Duration
+	// WriteTimeout is the deadline of each message write;
+	// zero keeps the server WriteTimeout for the whole stream.
+	WriteTimeout time.Duration
+	// MaxLifetime is how long a stream lasts, give or take 10%,
+	// before being closed, the client then reconnects; zero means no limit.
+	MaxLifetime time.Duration
+	// Closing is closed when the server is shutting down:
+	// the open streams are closed.
+	Closing <-chan struct{}
+}
+
+func SSE(opts SSEOptions) http.Handler {
 	return &handler{
-		cli: cli,
+		hub:          opts.Hub,
+		cli:          opts.Client,
+		heartbeat:    opts.Heartbeat,
+		retry:        opts.Retry,
+		writeTimeout: opts.WriteTimeout,
+		maxLifetime:  opts.MaxLifetime,
+		closing:      opts.Closing,
 	}
 }
 
 var _ http.Handler = (*handler)(nil)
 
 type handler struct {
-	cli clientv3.Watcher
+	hub          *hub.Hub
+	cli          Client
+	heartbeat    time.Duration
+	retry        time.Duration
+	writeTimeout time.Duration
+	maxLifetime  time.Duration
+	closing      <-chan struct{}
 }
 
 // @title EventSSE API
@@ -33,9 +89,16 @@ type handler struct {
 
 // Health godoc
 // @Summary SSE Endpoint
-// @Description Get available events notifications
+// @Description Get available events notifications; the id of each notification is the
+// @Description etcd revision of the event, send it back in the Last-Event-ID header to resume the stream
 // @ID notifications
 // @Produce  json
+// @Param Last-Event-ID header int false "Revision of the last received event"
+// @Param composition query string false "Composition Identifier"
+// @Param namespace query string false "Involved object namespaces (comma separated)"
+// @Param kind query string false "Involved object kinds (comma separated)"
+// @Param type query string false "Event types (Normal, Warning)"
+// @Param reason query string false "Event reasons (comma separated)"
 // @Success 200 {array} types.Event
 // @Router /pub [get]
 func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
@@ -51,6 +114,12 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 
 	r.setCORSHeaders(wri)
 
+	flt, err := filterFromQuery(req.URL.Query())
+	if err != nil {
+		http.Error(wri, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// === SSE Headers ===
 	wri.Header().Set("Content-Type", "text/event-stream")
 	wri.Header().Set("Cache-Control", "no-cache")
@@ -62,79 +131,322 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 		Timestamp().
 		Logger()
 
-	f, ok := wri.(http.Flusher)
-	if !ok {
+	if _, ok := wri.(http.Flusher); !ok {
 		log.Error().Msg("http.ResponseWriter does not implement http.Flusher")
 		http.Error(wri, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Fprintln(wri, "event: connection-established")
-	fmt.Fprintln(wri, "id: 88888888")
-	fmt.Fprintf(wri, "data: %s\n\n", `{"info": "Ready to watch events"}`)
-	f.Flush()
+	out := newStream(wri, r.writeTimeout)
+
+	if r.retry > 0 {
+		if err := out.retry(r.retry); err != nil {
+			log.Warn().Msgf("Writing SSE: %s", err.Error())
+			return
+		}
+	}
+
+	// no id: the browser keeps the last event id for the next reconnection
+	err = out.send(func(wri http.ResponseWriter) {
+		fmt.Fprintln(wri, "event: connection-established")
+		fmt.Fprintf(wri, "data: %s\n\n", `{"info": "Ready to watch events"}`)
+	})
+	if err != nil {
+		log.Warn().Msgf("Writing SSE: %s", err.Error())
+		return
+	}
+
+	key := flt.prefix()
+
+	// subscribe before catching up: the events put meanwhile are
+	// buffered and the ones already sent skipped
+	sub := r.hub.Subscribe(key)
+	defer r.hub.Unsubscribe(sub)
+
+	// sent is the revision of the last event sent to the client
+	sent := lastEventID(req)
+
+	var cu *catchUp
+	if sent > 0 {
+		log.Debug().Int64("revision", sent).Str("key", key).Msg("Resuming SSE stream")
+
+		cu = r.startCatchUp(ctx, key, sent)
+		defer cu.stop()
+	}
+
+	var heartbeat <-chan time.Time
+	if r.heartbeat > 0 {
+		tick := time.NewTicker(r.heartbeat)
+		defer tick.Stop()
+		heartbeat = tick.C
+	}
+
+	var expired <-chan time.Time
+	if r.maxLifetime > 0 {
+		timer := time.NewTimer(lifetime(r.maxLifetime))
+		defer timer.Stop()
+		expired = timer.C
+	}
 
-	watchChan := r.cli.Watch(ctx, store.RootKey, clientv3.WithPrefix())
 	for {
+		live, catchup := sub.Events(), cu.events()
+		if cu != nil && cu.head != nil {
+			// the live events wait for the catch-up to reach the first one
+			live = nil
+		}
+
 		select {
 		case <-ctx.Done():
 			log.Info().Msg("SSE client disconnected")
 			return
 
-		case watchResp, ok := <-watchChan:
+		case <-expired:
+			log.Debug().Str("key", key).Msg("SSE stream lifetime expired, closing")
+			return
+
+		case <-r.closing:
+			log.Debug().Str("key", key).Msg("Server shutting down, closing SSE stream")
+			return
+
+		case <-heartbeat:
+			if err := out.heartbeat(); err != nil {
+				log.Warn().Msgf("Writing SSE heartbeat: %s", err.Error())
+				return
+			}
+
+		case res, ok := <-catchup:
 			if !ok {
-				log.Warn().Msg("Etcd watch channel closed")
+				log.Warn().Str("key", key).Msg("Etcd catch-up watch closed")
 				return
 			}
 
-			for _, ev := range watchResp.Events {
-				key := string(ev.Kv.Key)
-				val := ev.Kv.Value
-				if len(val) == 0 {
-					continue
-				}
+			next, err := r.forward(ctx, out, log, &flt, key, cu, res, sent)
+			if err != nil {
+				log.Error().Msgf("Catching up SSE stream: %s", err.Error())
+				return
+			}
+			sent = next
 
-				var obj corev1.Event
-				if err := json.Unmarshal(val, &obj); err != nil {
-					log.Warn().Str("key", key).Msgf("Decoding JSON event: %s", err.Error())
-					continue
-				}
+		case kv, ok := <-live:
+			if !ok {
+				log.Warn().Str("key", key).Msgf("SSE subscription closed: %s", sub.Err())
+				return
+			}
 
-				cid := labels.CompositionID(&obj)
-				belongsToComposition := len(cid) > 0
+			if cu != nil {
+				cu.head = kv
+				break
+			}
 
-				eventName := "krateo"
-				if len(cid) > 0 {
-					eventName = cid
-				}
+			if kv.ModRevision <= sent {
+				continue
+			}
+			if err := r.send(out, log, &flt, kv); err != nil {
+				log.Warn().Msgf("Writing SSE: %s", err.Error())
+				return
+			}
+			sent = kv.ModRevision
+		}
+
+		if cu != nil && cu.reached(sent) {
+			log.Debug().Int64("revision", sent).Str("key", key).Msg("SSE stream caught up")
 
-				zle := log.Debug().
-					Str("id", key).
-					Str("reason", obj.Reason).
-					Str("message", obj.Message).
-					Str("involvedObject.Name", obj.InvolvedObject.Name).
-					Str("involvedObject.Namespace", obj.InvolvedObject.Namespace)
-
-				if belongsToComposition {
-					zle.Str("event", cid)
-				} else {
-					zle.Str("event", "krateo")
+			cu.stop()
+			head := cu.head
+			cu = nil
+
+			if head != nil && head.ModRevision > sent {
+				if err := r.send(out, log, &flt, head); err != nil {
+					log.Warn().Msgf("Writing SSE: %s", err.Error())
+					return
 				}
-				zle.Msg("Sending SSE")
-				zle = nil
-
-				fmt.Fprintf(wri, "event: %s\n", eventName)
-				fmt.Fprintf(wri, "id: %s\n", key)
-				fmt.Fprintf(wri, "data: %s\n\n", string(val))
-				f.Flush()
-
-				log.Debug().
-					Str("event", eventName).
-					Str("key", key).
-					Msg("SSE sent")
+				sent = head.ModRevision
+			}
+		}
+	}
+}
+
+// catchUp is the etcd watch sending the events missed by a client
+// resuming the stream, until it reaches the live events of the hub.
+type catchUp struct {
+	wc     clientv3.WatchChan
+	cancel context.CancelFunc
+	// head is the first live event; it's held until the catch-up
+	// has sent all the events up to its revision
+	head *mvccpb.KeyValue
+	// replayed is set once the missed events have been read from
+	// the store instead, the live ones follow them
+	replayed bool
+}
+
+// startCatchUp opens the watch of the events after the given revision.
+func (r *handler) startCatchUp(ctx context.Context, key string, rev int64) *catchUp {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &catchUp{
+		wc:     r.cli.Watch(ctx, key, clientv3.WithPrefix(), clientv3.WithRev(rev+1)),
+		cancel: cancel,
+	}
+}
+
+// events returns the channel of the catch-up watch;
+// a nil catchUp never receives.
+func (c *catchUp) events() clientv3.WatchChan {
+	if c == nil {
+		return nil
+	}
+	return c.wc
+}
+
+// reached tells if the events up to the first live one have been sent.
+func (c *catchUp) reached(sent int64) bool {
+	return c.replayed || (c.head != nil && sent >= c.head.ModRevision)
+}
+
+func (c *catchUp) stop() {
+	c.cancel()
+}
+
+// forward sends the events of the catch-up watch response; it returns
+// the revision of the last event sent.
+func (r *handler) forward(ctx context.Context, out *stream, log zerolog.Logger, flt *filter, key string, cu *catchUp, res clientv3.WatchResponse, sent int64) (int64, error) {
+	if res.CompactRevision != 0 {
+		// the events since the last one received by the client
+		// are gone from the history: send the stored ones
+		log.Warn().
+			Int64("revision", sent).
+			Int64("compactRevision", res.CompactRevision).
+			Msg("Resume revision compacted, replaying the stored events")
+
+		cu.stop()
+		cu.replayed = true
+
+		return r.replay(ctx, out, log, flt, key, sent)
+	}
+
+	if err := res.Err(); err != nil {
+		return sent, err
+	}
+
+	for _, ev := range res.Events {
+		if ev.Type != mvccpb.PUT || ev.Kv.ModRevision <= sent {
+			continue
+		}
+		if err := r.send(out, log, flt, ev.Kv); err != nil {
+			return sent, err
+		}
+		sent = ev.Kv.ModRevision
+	}
+
+	return sent, nil
+}
+
+// replay sends the stored events under the key modified after the given
+// revision, oldest first, a page at a time; it returns the revision they
+// were read at.
+func (r *handler) replay(ctx context.Context, out *stream, log zerolog.Logger, flt *filter, key string, rev int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, replayTimeout)
+	defer cancel()
+
+	opts := []clientv3.OpOption{
+		clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortAscend),
+		clientv3.WithLimit(replayPageSize),
+	}
+
+	var at int64
+	for {
+		res, err := r.cli.Get(ctx, key, append(opts, clientv3.WithMinModRev(rev+1))...)
+		if err != nil {
+			return rev, err
+		}
+
+		if at == 0 {
+			// the next pages are read at the same revision
+			at = res.Header.Revision
+			opts = append(opts, clientv3.WithRev(at))
+		}
+
+		for _, kv := range res.Kvs {
+			if err := r.send(out, log, flt, kv); err != nil {
+				return rev, err
 			}
+			rev = kv.ModRevision
 		}
+
+		if !res.More || len(res.Kvs) == 0 {
+			return at, nil
+		}
+	}
+}
+
+// send writes the event as SSE, named after its composition
+// identifier and identified by its etcd revision; the events
+// not matching the filter are skipped.
+func (r *handler) send(out *stream, log zerolog.Logger, flt *filter, kv *mvccpb.KeyValue) error {
+	eventName, ok := prepare(log, flt, kv)
+	if !ok {
+		return nil
+	}
+
+	return out.send(func(wri http.ResponseWriter) {
+		fmt.Fprintf(wri, "event: %s\n", eventName)
+		fmt.Fprintf(wri, "id: %d\n", kv.ModRevision)
+		fmt.Fprintf(wri, "data: %s\n\n", bytes.TrimSpace(kv.Value))
+	})
+}
+
+// prepare decodes the stored event and returns its name, the
+// composition identifier; it's false when the event must not
+// be sent (invalid or not matching the filter).
+func prepare(log zerolog.Logger, flt *filter, kv *mvccpb.KeyValue) (string, bool) {
+	key := string(kv.Key)
+	if len(kv.Value) == 0 {
+		return "", false
 	}
+
+	var obj corev1.Event
+	if err := json.Unmarshal(kv.Value, &obj); err != nil {
+		log.Warn().Str("key", key).Msgf("Decoding JSON event: %s", err.Error())
+		return "", false
+	}
+
+	if !flt.match(&obj) {
+		return "", false
+	}
+
+	eventName := "krateo"
+	if cid := labels.CompositionID(&obj); len(cid) > 0 {
+		eventName = cid
+	}
+
+	log.Debug().
+		Str("key", key).
+		Int64("id", kv.ModRevision).
+		Str("event", eventName).
+		Str("reason", obj.Reason).
+		Str("message", obj.Message).
+		Str("involvedObject.Name", obj.InvolvedObject.Name).
+		Str("involvedObject.Namespace", obj.InvolvedObject.Namespace).
+		Msg("Sending event")
+
+	return eventName, true
+}
+
+// lastEventID returns the revision of the last event received by
+// the client before reconnecting (zero if missing or invalid).
+func lastEventID(req *http.Request) int64 {
+	val := strings.TrimSpace(req.Header.Get("Last-Event-ID"))
+	if len(val) == 0 {
+		return 0
+	}
+
+	rev, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || rev < 0 {
+		return 0
+	}
+
+	return rev
 }
 
 // setCORSHeaders aggiunge header CORS generali
@@ -0,0 +1,308 @@
+package pub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/hub"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeClient replays a scripted sequence of watch responses
+// and serves the stored events.
+type fakeClient struct {
+	clientv3.Watcher
+	clientv3.KV
+
+	mu      sync.Mutex
+	watches [][]clientv3.WatchResponse
+	open    bool
+	keys    []string
+	// catchUp is sent by the watches from a revision, which stay open
+	catchUp []clientv3.WatchResponse
+	revs    []int64
+	stored  []*mvccpb.KeyValue
+	current int64
+	limits  []int64
+}
+
+func (c *fakeClient) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan clientv3.WatchResponse, 8)
+
+	if rev := clientv3.OpGet(key, opts...).Rev(); rev > 0 {
+		c.revs = append(c.revs, rev)
+		for _, el := range c.catchUp {
+			ch <- el
+		}
+		return ch
+	}
+
+	c.keys = append(c.keys, key)
+
+	if len(c.watches) > 0 {
+		for _, el := range c.watches[0] {
+			ch <- el
+		}
+		c.watches = c.watches[1:]
+	}
+	if !c.open {
+		close(ch)
+	}
+
+	return ch
+}
+
+func (c *fakeClient) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+
+	c.mu.Lock()
+	c.limits = append(c.limits, op.Limit())
+	c.mu.Unlock()
+
+	res := &clientv3.GetResponse{Header: &pb.ResponseHeader{Revision: c.current}}
+	for _, kv := range c.stored {
+		if kv.ModRevision < op.MinModRev() {
+			continue
+		}
+		if op.Limit() > 0 && len(res.Kvs) == int(op.Limit()) {
+			res.More = true
+			break
+		}
+		res.Kvs = append(res.Kvs, kv)
+	}
+
+	return res, nil
+}
+
+func keyValue(rev int64, uid string) *mvccpb.KeyValue {
+	return &mvccpb.KeyValue{
+		Key:         []byte("krateo.io.events/" + uid),
+		Value:       []byte(`{"metadata":{"uid":"` + uid + `"},"reason":"LoremIpsum"}` + "\n"),
+		ModRevision: rev,
+	}
+}
+
+func serve(cli *fakeClient, req *http.Request) *httptest.ResponseRecorder {
+	return serveWithOptions(SSEOptions{Client: cli}, cli, req)
+}
+
+func serveWithOptions(opts SSEOptions, cli *fakeClient, req *http.Request) *httptest.ResponseRecorder {
+	opts.Hub = hub.New(hub.Options{Watcher: cli})
+
+	rec := httptest.NewRecorder()
+	SSE(opts).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSSEResume(t *testing.T) {
+	cli := &fakeClient{
+		watches: [][]clientv3.WatchResponse{
+			{{Events: []*clientv3.Event{
+				// put after the subscription, already caught up
+				{Type: mvccpb.PUT, Kv: keyValue(9, "b")},
+				{Type: mvccpb.PUT, Kv: keyValue(11, "c")},
+			}}},
+		},
+		catchUp: []clientv3.WatchResponse{
+			{Events: []*clientv3.Event{
+				{Type: mvccpb.PUT, Kv: keyValue(7, "a")},
+				{Type: mvccpb.DELETE, Kv: keyValue(8, "a")},
+			}},
+			{Events: []*clientv3.Event{
+				{Type: mvccpb.PUT, Kv: keyValue(9, "b")},
+			}},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+	req.Header.Set("Last-Event-ID", "5")
+
+	body := serve(cli, req).Body.String()
+	expectEvents(t, body, 7, 9, 11)
+
+	if len(cli.revs) != 1 || cli.revs[0] != 6 {
+		t.Errorf("catch-up watch revisions: got %v, expected [6]", cli.revs)
+	}
+	if len(cli.limits) != 0 {
+		t.Errorf("unexpected stored events replay")
+	}
+}
+
+func TestSSEResumeCompacted(t *testing.T) {
+	cli := &fakeClient{
+		watches: [][]clientv3.WatchResponse{
+			{{Events: []*clientv3.Event{
+				{Type: mvccpb.PUT, Kv: keyValue(9, "b")},
+				{Type: mvccpb.PUT, Kv: keyValue(11, "c")},
+			}}},
+		},
+		catchUp: []clientv3.WatchResponse{
+			{CompactRevision: 8},
+		},
+		stored: []*mvccpb.KeyValue{
+			keyValue(4, "old"),
+			keyValue(7, "a"),
+			keyValue(9, "b"),
+		},
+		current: 10,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+	req.Header.Set("Last-Event-ID", "5")
+
+	body := serve(cli, req).Body.String()
+	expectEvents(t, body, 7, 9, 11)
+
+	if len(cli.limits) != 1 || cli.limits[0] != replayPageSize {
+		t.Errorf("replay limits: got %v, expected [%d]", cli.limits, replayPageSize)
+	}
+}
+
+// expectEvents checks that the stream has sent the events with
+// the given ids, in order and only once.
+func expectEvents(t *testing.T, body string, ids ...int64) {
+	t.Helper()
+
+	got := []int64{}
+	for _, line := range strings.Split(body, "\n") {
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			n, _ := strconv.ParseInt(id, 10, 64)
+			got = append(got, n)
+		}
+	}
+
+	if !slices.Equal(got, ids) {
+		t.Errorf("event ids: got %v, expected %v:\n%s", got, ids, body)
+	}
+}
+
+func TestSSEFilter(t *testing.T) {
+	warn := keyValue(3, "w")
+	warn.Value = []byte(`{"metadata":{"uid":"w"},"type":"Warning","reason":"Failed"}`)
+
+	cli := &fakeClient{
+		watches: [][]clientv3.WatchResponse{
+			{{Events: []*clientv3.Event{
+				{Type: mvccpb.PUT, Kv: keyValue(2, "n")},
+				{Type: mvccpb.PUT, Kv: warn},
+			}}},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/notifications?composition=ABC&type=warning", nil)
+	rec := serve(cli, req)
+
+	if len(cli.keys) != 1 || cli.keys[0] != "krateo.io.events/comp-abc/" {
+		t.Errorf("watched keys: got %v, expected [krateo.io.events/comp-abc/]", cli.keys)
+	}
+
+	body := rec.Body.String()
+	if strings.Contains(body, "id: 2\n") || !strings.Contains(body, "id: 3\n") {
+		t.Errorf("expected the warning event only:\n%s", body)
+	}
+}
+
+func TestSSEInvalidFilter(t *testing.T) {
+	for _, query := range []string{"type=Error", "composition=..", "composition=abc/..%2F..%2Fother"} {
+		req := httptest.NewRequest(http.MethodGet, "/notifications?"+query, nil)
+		rec := serve(&fakeClient{}, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status code: got %d, expected %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSSEMaxLifetime(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+	rec := serveWithOptions(SSEOptions{
+		Heartbeat:   10 * time.Millisecond,
+		Retry:       2 * time.Second,
+		MaxLifetime: 100 * time.Millisecond,
+	}, &fakeClient{open: true}, req)
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "retry: 2000\n\n") {
+		t.Errorf("expected the retry hint first:\n%s", body)
+	}
+	if !strings.Contains(body, ": heartbeat\n\n") {
+		t.Errorf("expected at least one heartbeat:\n%s", body)
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	const d = 30 * time.Minute
+
+	lo, hi := d, d
+	for range 1000 {
+		got := lifetime(d)
+		if got < 27*time.Minute || got > 33*time.Minute {
+			t.Fatalf("got %v, expected %v ± 10%%", got, d)
+		}
+		lo, hi = min(lo, got), max(hi, got)
+	}
+
+	if lo == d || hi == d {
+		t.Errorf("expected the lifetimes to be spread around %v, got [%v, %v]", d, lo, hi)
+	}
+}
+
+func TestSSEClosing(t *testing.T) {
+	closing := make(chan struct{})
+	close(closing)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+		serveWithOptions(SSEOptions{Closing: closing}, &fakeClient{open: true}, req)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed on server shutdown")
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	tests := []struct {
+		val string
+		exp int64
+	}{
+		{"", 0},
+		{"42", 42},
+		{" 42 ", 42},
+		{"krateo.io.events/comp-abc/uid", 0},
+		{"-1", 0},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+		req.Header.Set("Last-Event-ID", tc.val)
+		if got := lastEventID(req); got != tc.exp {
+			t.Errorf("%q: got %d, expected %d", tc.val, got, tc.exp)
+		}
+	}
+}
+
+// watched returns the keys watched so far.
+func (c *fakeClient) watched() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.keys...)
+}
@@ -0,0 +1,65 @@
+package pub
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// lifetimeJitter is the fraction of the maximum lifetime randomly
+// added or removed, so that the clients connected together, e.g.
+// after a restart, don't all reconnect at the same time.
+const lifetimeJitter = 0.1
+
+// lifetime returns the maximum lifetime d of a connection, jittered.
+func lifetime(d time.Duration) time.Duration {
+	return d + time.Duration((2*rand.Float64()-1)*lifetimeJitter*float64(d))
+}
+
+// stream writes the SSE messages to the client; each message
+// must be flushed within the write timeout.
+type stream struct {
+	wri          http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+}
+
+func newStream(wri http.ResponseWriter, writeTimeout time.Duration) *stream {
+	return &stream{
+		wri:          wri,
+		rc:           http.NewResponseController(wri),
+		writeTimeout: writeTimeout,
+	}
+}
+
+// send writes and flushes a message built by fn.
+func (s *stream) send(fn func(wri http.ResponseWriter)) error {
+	if s.writeTimeout > 0 {
+		// replaces the server WriteTimeout, which
+		// would end any stream longer than it
+		err := s.rc.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+
+	fn(s.wri)
+	return s.rc.Flush()
+}
+
+// retry tells the client how long to wait before reconnecting.
+func (s *stream) retry(d time.Duration) error {
+	return s.send(func(wri http.ResponseWriter) {
+		fmt.Fprintf(wri, "retry: %d\n\n", d.Milliseconds())
+	})
+}
+
+// heartbeat writes a comment, ignored by the clients, keeping
+// the connection active for the proxies in between.
+func (s *stream) heartbeat() error {
+	return s.send(func(wri http.ResponseWriter) {
+		fmt.Fprint(wri, ": heartbeat\n\n")
+	})
+}
@@ -0,0 +1,466 @@
+package pub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/krateoplatformops/eventsse/internal/hub"
+	"github.com/rs/zerolog"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+const (
+	// wsReadLimit is the maximum size of the client messages.
+	wsReadLimit = 4096
+	// wsCloseTimeout is the deadline of the close message.
+	wsCloseTimeout = time.Second
+)
+
+// WebSocket message types; the clients send only
+// the subscribe and unsubscribe ones.
+const (
+	wsTypeConnected    = "connection-established"
+	wsTypeEvent        = "event"
+	wsTypeSubscribe    = "subscribe"
+	wsTypeSubscribed   = "subscribed"
+	wsTypeUnsubscribe  = "unsubscribe"
+	wsTypeUnsubscribed = "unsubscribed"
+	wsTypeError        = "error"
+)
+
+// wsMessage is the envelope of all the WebSocket messages; the
+// event ones carry the same id, name and data sent on the SSE stream.
+type wsMessage struct {
+	Type        string          `json:"type"`
+	ID          int64           `json:"id,omitempty"`
+	Event       string          `json:"event,omitempty"`
+	Composition string          `json:"composition,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// WebSocketOptions configures the /ws/notifications stream.
+type WebSocketOptions struct {
+	// Hub delivers the live events.
+	Hub *hub.Hub
+	// Heartbeat is how often a ping is sent; the connection is closed
+	// when there is no pong within two periods. Zero disables the pings.
+	Heartbeat time.Duration
+	// WriteTimeout is the deadline of each message write.
+	WriteTimeout time.Duration
+	// MaxLifetime is how long a connection lasts, give or take 10%,
+	// before being closed, the client then reconnects; zero means no limit.
+	MaxLifetime time.Duration
+	// MaxSubscriptions is how many compositions a connection can
+	// subscribe at once; zero means no limit.
+	MaxSubscriptions int
+	// AllowedOrigins are the origins, besides the server one, of the
+	// browsers allowed to connect: the CORS allowed origins, "*"
+	// allows any origin and a single "*" in an origin matches any
+	// string (e.g. "https://*.example.com").
+	AllowedOrigins []string
+	// Closing is closed when the server is shutting down:
+	// the open connections are closed.
+	Closing <-chan struct{}
+}
+
+func WebSocket(opts WebSocketOptions) http.Handler {
+	return &wsHandler{
+		hub:              opts.Hub,
+		heartbeat:        opts.Heartbeat,
+		writeTimeout:     opts.WriteTimeout,
+		maxLifetime:      opts.MaxLifetime,
+		maxSubscriptions: opts.MaxSubscriptions,
+		closing:          opts.Closing,
+		upgrader: websocket.Upgrader{
+			// the browsers don't apply the CORS policy to WebSocket
+			CheckOrigin: func(req *http.Request) bool {
+				return allowedOrigin(req, opts.AllowedOrigins)
+			},
+		},
+	}
+}
+
+// allowedOrigin tells whether the request comes from the server
+// origin, from one of the allowed ones or from a client other than
+// a browser, i.e. without the Origin header.
+func allowedOrigin(req *http.Request, allowed []string) bool {
+	origin := req.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+
+	for _, el := range allowed {
+		if el == "*" || strings.EqualFold(el, origin) {
+			return true
+		}
+
+		prefix, suffix, ok := strings.Cut(strings.ToLower(el), "*")
+		if ok && len(origin) >= len(prefix)+len(suffix) &&
+			strings.HasPrefix(strings.ToLower(origin), prefix) &&
+			strings.HasSuffix(strings.ToLower(origin), suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var _ http.Handler = (*wsHandler)(nil)
+
+type wsHandler struct {
+	hub              *hub.Hub
+	heartbeat        time.Duration
+	writeTimeout     time.Duration
+	maxLifetime      time.Duration
+	maxSubscriptions int
+	closing          <-chan struct{}
+	upgrader         websocket.Upgrader
+}
+
+// WebSocket godoc
+// @Summary WebSocket Endpoint
+// @Description Get available events notifications over WebSocket; send
+// @Description {"type":"subscribe","composition":"<id>"} and {"type":"unsubscribe","composition":"<id>"}
+// @Description messages to change the watched compositions (an empty composition means all the events)
+// @ID ws-notifications
+// @Param composition query string false "Composition Identifier"
+// @Param namespace query string false "Involved object namespaces (comma separated)"
+// @Param kind query string false "Involved object kinds (comma separated)"
+// @Param type query string false "Event types (Normal, Warning)"
+// @Param reason query string false "Event reasons (comma separated)"
+// @Success 101
+// @Router /ws/notifications [get]
+func (r *wsHandler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
+	flt, err := filterFromQuery(req.URL.Query())
+	if err != nil {
+		http.Error(wri, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log := zerolog.New(os.Stdout).With().
+		Str("service", "eventsse").
+		Timestamp().
+		Logger()
+
+	conn, err := r.upgrader.Upgrade(wri, req, nil)
+	if err != nil {
+		// the upgrader has already replied to the client
+		log.Warn().Msgf("Upgrading to WebSocket: %s", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadLimit(wsReadLimit)
+
+	c := &wsConn{
+		wsHandler: r,
+		conn:      conn,
+		log:       log,
+		flt:       flt,
+		subs:      map[string]*wsSubscription{},
+		in:        make(chan wsItem),
+	}
+	defer c.unsubscribeAll()
+
+	c.run(req.Context())
+}
+
+// wsSubscription is the hub subscription of a composition.
+type wsSubscription struct {
+	sub  *hub.Subscription
+	stop chan struct{}
+}
+
+// wsItem is an event, or the end, of a subscription.
+type wsItem struct {
+	composition string
+	ws          *wsSubscription
+	kv          *mvccpb.KeyValue
+	closed      bool
+}
+
+// wsConn is a WebSocket client: all the messages are written by
+// the run goroutine, the control messages are read by another one.
+type wsConn struct {
+	*wsHandler
+	conn *websocket.Conn
+	log  zerolog.Logger
+	// flt selects the events of all the subscriptions; its
+	// composition is only the initial subscription.
+	flt  filter
+	subs map[string]*wsSubscription
+	in   chan wsItem
+}
+
+func (c *wsConn) run(ctx context.Context) {
+	done := make(chan struct{})
+	defer close(done)
+
+	ctrl := make(chan []byte)
+	readErr := make(chan error, 1)
+	go c.read(ctrl, readErr, done)
+
+	if err := c.write(wsMessage{Type: wsTypeConnected}); err != nil {
+		c.log.Warn().Msgf("Writing WebSocket message: %s", err.Error())
+		return
+	}
+
+	if err := c.subscribe(c.flt.composition); err != nil {
+		c.log.Warn().Msgf("Writing WebSocket message: %s", err.Error())
+		return
+	}
+
+	var heartbeat <-chan time.Time
+	if c.heartbeat > 0 {
+		tick := time.NewTicker(c.heartbeat)
+		defer tick.Stop()
+		heartbeat = tick.C
+	}
+
+	var expired <-chan time.Time
+	if c.maxLifetime > 0 {
+		timer := time.NewTimer(lifetime(c.maxLifetime))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		var err error
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case err := <-readErr:
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.log.Info().Msgf("WebSocket client disconnected: %s", err.Error())
+			}
+			return
+
+		case <-expired:
+			c.log.Debug().Msg("WebSocket connection lifetime expired, closing")
+			c.close(websocket.CloseGoingAway, "connection lifetime expired")
+			return
+
+		case <-c.closing:
+			c.log.Debug().Msg("Server shutting down, closing WebSocket connection")
+			c.close(websocket.CloseGoingAway, "server shutting down")
+			return
+
+		case <-heartbeat:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.deadline()))
+
+		case dat := <-ctrl:
+			err = c.control(dat)
+
+		case it := <-c.in:
+			if c.subs[it.composition] != it.ws {
+				// already unsubscribed
+				continue
+			}
+
+			if it.closed {
+				err := it.ws.sub.Err()
+				c.log.Warn().Str("composition", it.composition).
+					Msgf("WebSocket subscription closed: %s", err)
+				c.close(websocket.CloseTryAgainLater, err.Error())
+				return
+			}
+
+			err = c.send(it.composition, it.kv)
+		}
+
+		if err != nil {
+			c.log.Warn().Msgf("Writing WebSocket message: %s", err.Error())
+			return
+		}
+	}
+}
+
+// read forwards the client messages until the connection fails.
+func (c *wsConn) read(ctrl chan<- []byte, readErr chan<- error, done <-chan struct{}) {
+	if c.heartbeat > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(2 * c.heartbeat))
+		c.conn.SetPongHandler(func(string) error {
+			return c.conn.SetReadDeadline(time.Now().Add(2 * c.heartbeat))
+		})
+	}
+
+	for {
+		_, dat, err := c.conn.ReadMessage()
+		if err != nil {
+			readErr <- err
+			return
+		}
+
+		select {
+		case ctrl <- dat:
+		case <-done:
+			return
+		}
+	}
+}
+
+// control handles a subscribe or unsubscribe message; the
+// invalid ones are answered with an error message.
+func (c *wsConn) control(dat []byte) error {
+	var msg wsMessage
+	if err := json.Unmarshal(dat, &msg); err != nil {
+		return c.write(wsMessage{Type: wsTypeError, Error: fmt.Sprintf("invalid message: %s", err.Error())})
+	}
+
+	switch msg.Type {
+	case wsTypeSubscribe:
+		return c.subscribe(msg.Composition)
+	case wsTypeUnsubscribe:
+		return c.unsubscribe(msg.Composition)
+	}
+
+	return c.write(wsMessage{Type: wsTypeError, Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+}
+
+// subscribe starts receiving the events of the composition,
+// or all the events if it's empty; the subscriptions past the
+// maximum are answered with an error message.
+func (c *wsConn) subscribe(composition string) error {
+	composition = strings.ToLower(strings.TrimSpace(composition))
+
+	if err := validateComposition(composition); err != nil {
+		return c.write(wsMessage{Type: wsTypeError, Composition: composition, Error: err.Error()})
+	}
+
+	if _, ok := c.subs[composition]; !ok {
+		if c.maxSubscriptions > 0 && len(c.subs) >= c.maxSubscriptions {
+			return c.write(wsMessage{
+				Type:        wsTypeError,
+				Composition: composition,
+				Error:       fmt.Sprintf("too many subscriptions (max %d)", c.maxSubscriptions),
+			})
+		}
+
+		flt := filter{composition: composition}
+
+		ws := &wsSubscription{
+			sub:  c.hub.Subscribe(flt.prefix()),
+			stop: make(chan struct{}),
+		}
+		c.subs[composition] = ws
+
+		go c.forward(composition, ws)
+
+		c.log.Debug().Str("composition", composition).Msg("WebSocket subscribed")
+	}
+
+	return c.write(wsMessage{Type: wsTypeSubscribed, Composition: composition})
+}
+
+func (c *wsConn) unsubscribe(composition string) error {
+	composition = strings.ToLower(strings.TrimSpace(composition))
+
+	ws, ok := c.subs[composition]
+	if !ok {
+		return c.write(wsMessage{Type: wsTypeError, Composition: composition, Error: "not subscribed"})
+	}
+
+	delete(c.subs, composition)
+	close(ws.stop)
+	c.hub.Unsubscribe(ws.sub)
+
+	c.log.Debug().Str("composition", composition).Msg("WebSocket unsubscribed")
+
+	return c.write(wsMessage{Type: wsTypeUnsubscribed, Composition: composition})
+}
+
+func (c *wsConn) unsubscribeAll() {
+	for composition, ws := range c.subs {
+		delete(c.subs, composition)
+		close(ws.stop)
+		c.hub.Unsubscribe(ws.sub)
+	}
+}
+
+// forward passes the subscription events to the run goroutine.
+func (c *wsConn) forward(composition string, ws *wsSubscription) {
+	for {
+		select {
+		case <-ws.stop:
+			return
+
+		case kv, ok := <-ws.sub.Events():
+			select {
+			case c.in <- wsItem{composition: composition, ws: ws, kv: kv, closed: !ok}:
+			case <-ws.stop:
+				return
+			}
+
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// send writes the event; while all the events are subscribed,
+// the ones of the composition subscriptions are duplicates.
+func (c *wsConn) send(composition string, kv *mvccpb.KeyValue) error {
+	if _, all := c.subs[""]; all && len(composition) > 0 {
+		return nil
+	}
+
+	eventName, ok := prepare(c.log, &c.flt, kv)
+	if !ok {
+		return nil
+	}
+
+	return c.write(wsMessage{
+		Type:  wsTypeEvent,
+		ID:    kv.ModRevision,
+		Event: eventName,
+		Data:  json.RawMessage(bytes.TrimSpace(kv.Value)),
+	})
+}
+
+func (c *wsConn) write(msg wsMessage) error {
+	if c.writeTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return err
+		}
+	}
+
+	return c.conn.WriteJSON(msg)
+}
+
+// close sends the close message; the connection is closed by ServeHTTP.
+func (c *wsConn) close(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsCloseTimeout))
+	if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		c.log.Debug().Msgf("Writing WebSocket close message: %s", err.Error())
+	}
+}
+
+// deadline is the write deadline of the pings.
+func (c *wsConn) deadline() time.Duration {
+	if c.writeTimeout > 0 {
+		return c.writeTimeout
+	}
+	return wsCloseTimeout
+}
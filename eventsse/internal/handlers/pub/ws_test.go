@@ -0,0 +1,170 @@
+package pub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/krateoplatformops/eventsse/internal/hub"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func dialWebSocket(t *testing.T, opts WebSocketOptions, cli *fakeClient, query string) *websocket.Conn {
+	t.Helper()
+
+	opts.Hub = hub.New(hub.Options{Watcher: cli})
+
+	srv := httptest.NewServer(WebSocket(opts))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func expectMessage(t *testing.T, conn *websocket.Conn, exp wsMessage) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var got wsMessage
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Type != exp.Type || got.ID != exp.ID || got.Composition != exp.Composition {
+		t.Fatalf("got %+v, expected %+v", got, exp)
+	}
+	if exp.Type == wsTypeError && len(got.Error) == 0 {
+		t.Fatalf("expected an error description: %+v", got)
+	}
+	if exp.Type == wsTypeEvent && len(got.Data) == 0 {
+		t.Fatalf("expected the event data: %+v", got)
+	}
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	warning := func(rev int64, uid string) *mvccpb.KeyValue {
+		kv := keyValue(rev, uid)
+		kv.Value = []byte(`{"metadata":{"uid":"` + uid + `"},"type":"Warning","reason":"Failed"}`)
+		return kv
+	}
+
+	cli := &fakeClient{
+		open: true,
+		watches: [][]clientv3.WatchResponse{
+			{{Events: []*clientv3.Event{
+				{Type: mvccpb.PUT, Kv: keyValue(2, "n")},
+				{Type: mvccpb.PUT, Kv: warning(3, "w")},
+			}}},
+			{{Events: []*clientv3.Event{
+				{Type: mvccpb.PUT, Kv: warning(5, "x")},
+			}}},
+		},
+	}
+
+	conn := dialWebSocket(t, WebSocketOptions{}, cli, "?composition=ABC&type=Warning")
+
+	expectMessage(t, conn, wsMessage{Type: wsTypeConnected})
+	expectMessage(t, conn, wsMessage{Type: wsTypeSubscribed, Composition: "abc"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeEvent, ID: 3})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeSubscribe, Composition: "def"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeSubscribed, Composition: "def"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeEvent, ID: 5})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeUnsubscribe, Composition: "ABC"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeUnsubscribed, Composition: "abc"})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeUnsubscribe, Composition: "abc"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeError, Composition: "abc"})
+
+	conn.WriteMessage(websocket.TextMessage, []byte("lorem ipsum"))
+	expectMessage(t, conn, wsMessage{Type: wsTypeError})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeEvent})
+	expectMessage(t, conn, wsMessage{Type: wsTypeError})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeSubscribe, Composition: "../.."})
+	expectMessage(t, conn, wsMessage{Type: wsTypeError, Composition: "../.."})
+
+	keys := cli.watched()
+	if len(keys) != 2 || keys[0] != "krateo.io.events/comp-abc/" || keys[1] != "krateo.io.events/comp-def/" {
+		t.Errorf("watched keys: got %v", keys)
+	}
+}
+
+func TestWebSocketMaxLifetime(t *testing.T) {
+	conn := dialWebSocket(t, WebSocketOptions{
+		MaxLifetime: 100 * time.Millisecond,
+	}, &fakeClient{open: true}, "")
+
+	expectMessage(t, conn, wsMessage{Type: wsTypeConnected})
+	expectMessage(t, conn, wsMessage{Type: wsTypeSubscribed})
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close message, got %v", err)
+	}
+}
+
+func TestWebSocketMaxSubscriptions(t *testing.T) {
+	conn := dialWebSocket(t, WebSocketOptions{MaxSubscriptions: 2}, &fakeClient{open: true}, "?composition=abc")
+
+	expectMessage(t, conn, wsMessage{Type: wsTypeConnected})
+	expectMessage(t, conn, wsMessage{Type: wsTypeSubscribed, Composition: "abc"})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeSubscribe, Composition: "def"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeSubscribed, Composition: "def"})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeSubscribe, Composition: "ghi"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeError, Composition: "ghi"})
+
+	// already subscribed
+	conn.WriteJSON(wsMessage{Type: wsTypeSubscribe, Composition: "abc"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeSubscribed, Composition: "abc"})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeUnsubscribe, Composition: "def"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeUnsubscribed, Composition: "def"})
+
+	conn.WriteJSON(wsMessage{Type: wsTypeSubscribe, Composition: "ghi"})
+	expectMessage(t, conn, wsMessage{Type: wsTypeSubscribed, Composition: "ghi"})
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		origin  string
+		allowed []string
+		exp     bool
+	}{
+		{"", nil, true},
+		{"http://eventsse.krateo.io", nil, true},
+		{"https://evil.example.com", nil, false},
+		{"https://evil.example.com", []string{"*"}, true},
+		{"https://app.krateo.io", []string{"https://app.krateo.io"}, true},
+		{"https://App.Krateo.io", []string{"https://app.krateo.io"}, true},
+		{"https://console.krateo.io", []string{"https://*.krateo.io"}, true},
+		{"https://krateo.io.evil.com", []string{"https://*.krateo.io"}, false},
+		{"http://console.krateo.io", []string{"https://*.krateo.io"}, false},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://eventsse.krateo.io/ws/notifications", nil)
+		if len(tc.origin) > 0 {
+			req.Header.Set("Origin", tc.origin)
+		}
+
+		if got := allowedOrigin(req, tc.allowed); got != tc.exp {
+			t.Errorf("%q %v: got %v, expected %v", tc.origin, tc.allowed, got, tc.exp)
+		}
+	}
+}
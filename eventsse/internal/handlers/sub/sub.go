@@ -1,11 +1,18 @@
 package sub
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/krateoplatformops/eventsse/internal/httputil/decode"
+	"github.com/krateoplatformops/eventsse/internal/httputil/header"
 	"github.com/krateoplatformops/eventsse/internal/labels"
 	"github.com/krateoplatformops/eventsse/internal/store"
 	"github.com/rs/zerolog"
@@ -13,6 +20,12 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+const (
+	ndjsonContentType = "application/x-ndjson"
+	// same limit applied by decode.JSONBody
+	maxBodyBytes = 1048576
+)
+
 type HandleOptions struct {
 	Store store.Store
 	TTL   time.Duration
@@ -38,8 +51,7 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 		Timestamp().
 		Logger()
 
-	var nfo corev1.Event
-	err := decode.JSONBody(wri, req, &nfo)
+	all, err := decodeEvents(wri, req)
 	if err != nil {
 		log.Error().Msg(err.Error())
 		if decode.IsEmptyBodyError(err) {
@@ -50,18 +62,100 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	key := r.store.PrepareKey(string(nfo.UID), labels.CompositionID(&nfo))
-	log.Info().Str("key", key).Msg("Event received")
+	keys := make([]string, 0, len(all))
+	for i := range all {
+		nfo := &all[i]
 
-	if err := r.store.Set(key, &nfo); err != nil {
-		log.Error().Msg(err.Error())
-		http.Error(wri, err.Error(), http.StatusInternalServerError)
-		return
-	}
+		key := r.store.PrepareKey(string(nfo.UID), labels.CompositionID(nfo))
+		log.Info().Str("key", key).Msg("Event received")
 
-	log.Info().Str("key", key).Msg("Event stored")
+		if err := r.store.Set(key, nfo); err != nil {
+			log.Error().Msg(err.Error())
+			http.Error(wri, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Info().Str("key", key).Msg("Event stored")
+		keys = append(keys, key)
+	}
 
 	wri.WriteHeader(http.StatusOK)
 	wri.Header().Set("Content-Type", "text/plain")
-	wri.Write([]byte(key))
+	wri.Write([]byte(strings.Join(keys, "\n")))
+}
+
+// decodeEvents reads the events from the request body, which
+// can be a single event, a JSON array of events or a stream of
+// newline delimited events (Content-Type: application/x-ndjson).
+func decodeEvents(wri http.ResponseWriter, req *http.Request) ([]corev1.Event, error) {
+	value, _ := header.ParseValueAndParams(req.Header, "Content-Type")
+	if value == ndjsonContentType {
+		return decodeNDJSON(wri, req)
+	}
+
+	var raw json.RawMessage
+	if err := decode.JSONBody(wri, req, &raw); err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		var all []corev1.Event
+		if err := strictUnmarshal(raw, &all); err != nil {
+			return nil, err
+		}
+		if len(all) == 0 {
+			return nil, &decode.MalformedRequest{Status: http.StatusNoContent, Msg: "Request body is empty"}
+		}
+		return all, nil
+	}
+
+	var nfo corev1.Event
+	if err := strictUnmarshal(raw, &nfo); err != nil {
+		return nil, err
+	}
+
+	return []corev1.Event{nfo}, nil
+}
+
+func decodeNDJSON(wri http.ResponseWriter, req *http.Request) ([]corev1.Event, error) {
+	req.Body = http.MaxBytesReader(wri, req.Body, maxBodyBytes)
+
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+
+	all := []corev1.Event{}
+	for {
+		var nfo corev1.Event
+		err := dec.Decode(&nfo)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, &decode.MalformedRequest{
+				Status: http.StatusBadRequest,
+				Msg:    fmt.Sprintf("Request body contains an invalid event (at line %d): %s", len(all)+1, err),
+			}
+		}
+		all = append(all, nfo)
+	}
+
+	if len(all) == 0 {
+		return nil, &decode.MalformedRequest{Status: http.StatusNoContent, Msg: "Request body is empty"}
+	}
+
+	return all, nil
+}
+
+func strictUnmarshal(dat []byte, dst any) error {
+	dec := json.NewDecoder(bytes.NewReader(dat))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return &decode.MalformedRequest{
+			Status: http.StatusBadRequest,
+			Msg:    fmt.Sprintf("Request body contains an invalid event: %s", err),
+		}
+	}
+
+	return nil
 }
@@ -76,6 +76,70 @@ func TestServeHTTP(t *testing.T) {
 			t.Errorf("expected response body %q, got %q", expectedKey, rr.Body.String())
 		}
 	})
+
+	batch := []corev1.Event{
+		{ObjectMeta: v1.ObjectMeta{Name: "test-event-1", UID: types.UID("test-uid-1")}},
+		{ObjectMeta: v1.ObjectMeta{Name: "test-event-2", UID: types.UID("test-uid-2")}},
+	}
+	expectedKeys := ms.PrepareKey("test-uid-1", "") + "\n" + ms.PrepareKey("test-uid-2", "")
+
+	t.Run("JSON Array", func(t *testing.T) {
+		eventBytes, _ := json.Marshal(batch)
+		req, err := http.NewRequest(http.MethodPost, "/events", bytes.NewBuffer(eventBytes))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status 200 OK, got %v", rr.Code)
+		}
+		if rr.Body.String() != expectedKeys {
+			t.Errorf("expected response body %q, got %q", expectedKeys, rr.Body.String())
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, el := range batch {
+			enc.Encode(el)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/events", &buf)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status 200 OK, got %v", rr.Code)
+		}
+		if rr.Body.String() != expectedKeys {
+			t.Errorf("expected response body %q, got %q", expectedKeys, rr.Body.String())
+		}
+	})
+
+	t.Run("Malformed NDJSON", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/events", bytes.NewBufferString("{\"reason\":\"Test\"}\n{malformed"))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 Bad Request, got %v", rr.Code)
+		}
+	})
 }
 
 var _ store.Store = (*MockStore)(nil)
@@ -0,0 +1,266 @@
+// Package hub shares the etcd watches among the SSE clients: a single
+// watch per key prefix is fanned out to all the subscribers of that
+// prefix through bounded per-subscriber buffers.
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// DefaultBufferSize is the default number of events
+	// each subscriber buffer can hold.
+	DefaultBufferSize = 64
+)
+
+var (
+	// ErrSlowConsumer is the reason of the subscriptions closed
+	// by the disconnect policy.
+	ErrSlowConsumer = errors.New("subscriber buffer full")
+	// ErrWatchClosed is the reason of the subscriptions closed
+	// because their etcd watch has ended.
+	ErrWatchClosed = errors.New("etcd watch closed")
+)
+
+// Policy is what happens when an event can't be
+// added to a subscriber buffer because it is full.
+type Policy string
+
+const (
+	// PolicyDrop discards the event; the subscriber misses it.
+	PolicyDrop Policy = "drop"
+	// PolicyDisconnect closes the subscription; the client
+	// can reconnect and resume from its last event.
+	PolicyDisconnect Policy = "disconnect"
+)
+
+// ParsePolicy returns the slow consumer policy with the given
+// name; an empty name means PolicyDisconnect.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return PolicyDisconnect, nil
+	case PolicyDrop, PolicyDisconnect:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("invalid slow consumer policy %q (expected %s or %s)",
+		s, PolicyDrop, PolicyDisconnect)
+}
+
+type Options struct {
+	Watcher clientv3.Watcher
+	// BufferSize is how many events each subscriber buffer can
+	// hold (default: DefaultBufferSize).
+	BufferSize int
+	// Policy is what happens when a subscriber buffer is
+	// full (default: PolicyDisconnect).
+	Policy Policy
+	Log    zerolog.Logger
+}
+
+// Hub multiplexes the etcd watches among the subscribers.
+type Hub struct {
+	watcher    clientv3.Watcher
+	bufferSize int
+	policy     Policy
+	log        zerolog.Logger
+
+	mu    sync.Mutex
+	feeds map[string]*feed
+}
+
+func New(opts Options) *Hub {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if len(opts.Policy) == 0 {
+		opts.Policy = PolicyDisconnect
+	}
+
+	return &Hub{
+		watcher:    opts.Watcher,
+		bufferSize: opts.BufferSize,
+		policy:     opts.Policy,
+		log:        opts.Log,
+		feeds:      map[string]*feed{},
+	}
+}
+
+// feed is the etcd watch of a key prefix.
+type feed struct {
+	key    string
+	cancel context.CancelFunc
+	subs   map[*Subscription]struct{}
+}
+
+// Subscription receives the events put under a key prefix.
+type Subscription struct {
+	key    string
+	events chan *mvccpb.KeyValue
+	err    error
+}
+
+// Events returns the channel delivering the subscribed events; it
+// is closed, after the buffered events, when the hub ends the
+// subscription.
+func (s *Subscription) Events() <-chan *mvccpb.KeyValue {
+	return s.events
+}
+
+// Err returns why the hub has ended the subscription; it must be
+// called only once the events channel is closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Subscribe starts receiving the events put under the key prefix
+// from now on; the etcd watch of the prefix is opened by its first
+// subscriber. Unsubscribe must be called when done.
+func (h *Hub) Subscribe(key string) *Subscription {
+	sub := &Subscription{
+		key:    key,
+		events: make(chan *mvccpb.KeyValue, h.bufferSize),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, ok := h.feeds[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		f = &feed{
+			key:    key,
+			cancel: cancel,
+			subs:   map[*Subscription]struct{}{},
+		}
+		h.feeds[key] = f
+
+		wc := h.watcher.Watch(ctx, key, clientv3.WithPrefix())
+		go h.run(f, wc)
+
+		watchesOpened.Inc()
+	}
+	f.subs[sub] = struct{}{}
+
+	return sub
+}
+
+// Unsubscribe stops the subscription; the etcd watch of the
+// prefix is closed together with its last subscriber.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, ok := h.feeds[sub.key]
+	if !ok {
+		return
+	}
+	if _, ok := f.subs[sub]; !ok {
+		return
+	}
+
+	delete(f.subs, sub)
+	if len(f.subs) == 0 {
+		f.cancel()
+		delete(h.feeds, f.key)
+	}
+}
+
+// run fans out the watched events until the watch ends.
+func (h *Hub) run(f *feed, wc clientv3.WatchChan) {
+	defer h.close(f, ErrWatchClosed)
+
+	for res := range wc {
+		if err := res.Err(); err != nil {
+			h.log.Warn().Str("key", f.key).Msgf("Etcd watch failed: %s", err.Error())
+			return
+		}
+
+		for _, ev := range res.Events {
+			if ev.Type != mvccpb.PUT || len(ev.Kv.Value) == 0 {
+				continue
+			}
+			h.broadcast(f, ev.Kv)
+		}
+	}
+}
+
+func (h *Hub) broadcast(f *feed, kv *mvccpb.KeyValue) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range f.subs {
+		select {
+		case sub.events <- kv:
+			continue
+		default:
+		}
+
+		if h.policy == PolicyDrop {
+			eventsDropped.Inc()
+			continue
+		}
+
+		h.log.Warn().Str("key", f.key).Int("buffer", h.bufferSize).
+			Msg("Disconnecting slow SSE subscriber")
+
+		subscribersDisconnected.Inc()
+		delete(f.subs, sub)
+		sub.err = ErrSlowConsumer
+		close(sub.events)
+	}
+}
+
+// close ends all the subscriptions of the feed.
+func (h *Hub) close(f *feed, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f.cancel()
+	if h.feeds[f.key] == f {
+		delete(h.feeds, f.key)
+	}
+
+	for sub := range f.subs {
+		delete(f.subs, sub)
+		sub.err = err
+		close(sub.events)
+	}
+}
+
+// Stats returns the number of the subscribers, of the events
+// waiting in their buffers and the most of them held by one
+// subscriber buffer (the lag of the slowest subscriber).
+func (h *Hub) Stats() (subscribers, buffered, maxLag int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, f := range h.feeds {
+		for sub := range f.subs {
+			n := len(sub.events)
+
+			subscribers++
+			buffered += n
+			maxLag = max(maxLag, n)
+		}
+	}
+
+	return
+}
+
+// Watches returns the number of the open etcd watches.
+func (h *Hub) Watches() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.feeds)
+}
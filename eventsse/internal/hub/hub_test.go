@@ -0,0 +1,202 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeWatcher hands out watches fed by the test.
+type fakeWatcher struct {
+	clientv3.Watcher
+
+	mu      sync.Mutex
+	watches map[string]chan clientv3.WatchResponse
+	ctxs    map[string]context.Context
+	calls   int
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{
+		watches: map[string]chan clientv3.WatchResponse{},
+		ctxs:    map[string]context.Context{},
+	}
+}
+
+func (w *fakeWatcher) Watch(ctx context.Context, key string, _ ...clientv3.OpOption) clientv3.WatchChan {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan clientv3.WatchResponse)
+	w.watches[key] = ch
+	w.ctxs[key] = ctx
+	w.calls++
+
+	return ch
+}
+
+func (w *fakeWatcher) put(key string, revs ...int64) {
+	w.mu.Lock()
+	ch := w.watches[key]
+	w.mu.Unlock()
+
+	res := clientv3.WatchResponse{}
+	for _, rev := range revs {
+		res.Events = append(res.Events, &clientv3.Event{
+			Type: mvccpb.PUT,
+			Kv:   &mvccpb.KeyValue{Key: []byte(key), Value: []byte("{}"), ModRevision: rev},
+		})
+	}
+	ch <- res
+}
+
+func TestHubSharesWatch(t *testing.T) {
+	w := newFakeWatcher()
+	h := New(Options{Watcher: w})
+
+	a := h.Subscribe("foo")
+	b := h.Subscribe("foo")
+	c := h.Subscribe("bar")
+
+	if w.calls != 2 {
+		t.Fatalf("watches: got %d, expected 2", w.calls)
+	}
+
+	w.put("foo", 1, 2)
+	for _, sub := range []*Subscription{a, b} {
+		for _, exp := range []int64{1, 2} {
+			if kv := <-sub.Events(); kv.ModRevision != exp {
+				t.Errorf("revision: got %d, expected %d", kv.ModRevision, exp)
+			}
+		}
+	}
+	if len(c.Events()) != 0 {
+		t.Error("expected no events on the other prefix")
+	}
+
+	h.Unsubscribe(a)
+	if w.ctxs["foo"].Err() != nil {
+		t.Fatal("watch closed while still subscribed")
+	}
+	h.Unsubscribe(b)
+	if w.ctxs["foo"].Err() == nil {
+		t.Error("watch not closed without subscribers")
+	}
+	if n := h.Watches(); n != 1 {
+		t.Errorf("open watches: got %d, expected 1", n)
+	}
+}
+
+func TestHubSlowConsumer(t *testing.T) {
+	tests := []struct {
+		policy  Policy
+		err     error
+		dropped float64
+		subs    int
+	}{
+		{PolicyDrop, nil, 1, 2},
+		{PolicyDisconnect, ErrSlowConsumer, 0, 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			w := newFakeWatcher()
+			h := New(Options{Watcher: w, BufferSize: 2, Policy: tc.policy})
+
+			dropped := testutil.ToFloat64(eventsDropped)
+
+			slow := h.Subscribe("foo")
+			fast := h.Subscribe("foo")
+
+			w.put("foo", 1, 2)
+			<-fast.Events()
+			<-fast.Events()
+			w.put("foo", 3)
+			<-fast.Events()
+
+			// waits for the end of the broadcast
+			if subs, _, _ := h.Stats(); subs != tc.subs {
+				t.Errorf("subscribers: got %d, expected %d", subs, tc.subs)
+			}
+			if n := testutil.ToFloat64(eventsDropped) - dropped; n != tc.dropped {
+				t.Errorf("dropped: got %v, expected %v", n, tc.dropped)
+			}
+
+			// the buffered events are delivered in any case
+			var got []int64
+			for _, exp := range []int64{1, 2} {
+				kv, ok := <-slow.Events()
+				if !ok {
+					t.Fatalf("subscription closed before revision %d", exp)
+				}
+				got = append(got, kv.ModRevision)
+			}
+			if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+				t.Errorf("revisions: got %v, expected [1 2]", got)
+			}
+
+			if tc.err != nil {
+				if _, ok := <-slow.Events(); ok {
+					t.Fatal("expected the subscription to be closed")
+				}
+				if !errors.Is(slow.Err(), tc.err) {
+					t.Errorf("err: got %v, expected %v", slow.Err(), tc.err)
+				}
+			}
+		})
+	}
+}
+
+func TestHubWatchClosed(t *testing.T) {
+	w := newFakeWatcher()
+	h := New(Options{Watcher: w})
+
+	sub := h.Subscribe("foo")
+	w.put("foo", 1)
+	close(w.watches["foo"])
+
+	if kv, ok := <-sub.Events(); !ok || kv.ModRevision != 1 {
+		t.Fatalf("expected the buffered event before the close, got %v", kv)
+	}
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("expected the subscription to be closed")
+	}
+	if !errors.Is(sub.Err(), ErrWatchClosed) {
+		t.Errorf("err: got %v, expected %v", sub.Err(), ErrWatchClosed)
+	}
+
+	// the next subscriber opens a new watch
+	h.Subscribe("foo")
+	if w.calls != 2 {
+		t.Errorf("watches: got %d, expected 2", w.calls)
+	}
+	h.Unsubscribe(sub)
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		val string
+		exp Policy
+		err bool
+	}{
+		{"", PolicyDisconnect, false},
+		{"drop", PolicyDrop, false},
+		{" Disconnect ", PolicyDisconnect, false},
+		{"block", "", true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParsePolicy(tc.val)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error: %v", tc.val, err)
+		}
+		if got != tc.exp {
+			t.Errorf("%q: got %q, expected %q", tc.val, got, tc.exp)
+		}
+	}
+}
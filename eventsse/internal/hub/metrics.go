@@ -0,0 +1,21 @@
+package hub
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	watchesOpened = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventsse_hub_watches_opened_total",
+		Help: "Number of etcd watches opened by the hub.",
+	})
+	eventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventsse_hub_events_dropped_total",
+		Help: "Number of events dropped because a subscriber buffer was full.",
+	})
+	subscribersDisconnected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventsse_hub_subscribers_disconnected_total",
+		Help: "Number of subscribers disconnected because their buffer was full.",
+	})
+)
@@ -5,10 +5,23 @@ import (
 )
 
 const (
-	keyCompositionID = "krateo.io/composition-id"
-	keyPatchedBy     = "krateo.io/patched-by"
+	DefaultCompositionIDKey = "krateo.io/composition-id"
+	keyPatchedBy            = "krateo.io/patched-by"
 )
 
+// keyCompositionID is the label holding the composition identifier;
+// it must match the one set by the eventrouter.
+var keyCompositionID = DefaultCompositionIDKey
+
+// SetCompositionIDKey changes the label holding the composition
+// identifier; it must be called before serving any request.
+func SetCompositionIDKey(key string) {
+	if len(key) == 0 {
+		key = DefaultCompositionIDKey
+	}
+	keyCompositionID = key
+}
+
 func WasPatchedByKrateo(obj *corev1.Event) bool {
 	labels := obj.GetLabels()
 	if len(labels) == 0 {
@@ -104,3 +104,26 @@ func TestCompositionID(t *testing.T) {
 		})
 	}
 }
+
+func TestSetCompositionIDKey(t *testing.T) {
+	defer SetCompositionIDKey("")
+
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				DefaultCompositionIDKey: "12345",
+				"example.io/owner-id":   "67890",
+			},
+		},
+	}
+
+	SetCompositionIDKey("example.io/owner-id")
+	if got := CompositionID(event); got != "67890" {
+		t.Errorf("CompositionID() = %v, want %v", got, "67890")
+	}
+
+	SetCompositionIDKey("")
+	if got := CompositionID(event); got != "12345" {
+		t.Errorf("CompositionID() = %v, want %v", got, "12345")
+	}
+}
@@ -59,6 +59,12 @@ func (c *Client) SetTTL(ttl int) {
 }
 
 func (c *Client) PrepareKey(eventId, compositionId string) string {
+	return PrepareKey(eventId, compositionId)
+}
+
+// PrepareKey returns the key of the event; without the event
+// identifier it's the prefix of all the composition events.
+func PrepareKey(eventId, compositionId string) string {
 	key := ""
 	if len(compositionId) > 0 {
 		key = path.Join(key, fmt.Sprintf("comp-%s", compositionId))
@@ -6,7 +6,9 @@ import (
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-func NewWatcher(options Options) (clientv3.Watcher, error) {
+// NewWatcher creates the etcd client used to watch the events; it
+// reads the stored ones too, when a watch can't be resumed.
+func NewWatcher(options Options) (*clientv3.Client, error) {
 	if len(options.Endpoints) == 0 {
 		options.Endpoints = DefaultOptions.Endpoints
 	}
@@ -21,5 +23,5 @@ func NewWatcher(options Options) (clientv3.Watcher, error) {
 		return nil, err
 	}
 
-	return clientv3.NewWatcher(cli), nil
+	return cli, nil
 }
@@ -17,10 +17,15 @@ import (
 	"github.com/krateoplatformops/eventsse/internal/handlers/health"
 	"github.com/krateoplatformops/eventsse/internal/handlers/pub"
 	"github.com/krateoplatformops/eventsse/internal/handlers/sub"
+	"github.com/krateoplatformops/eventsse/internal/hub"
+	"github.com/krateoplatformops/eventsse/internal/labels"
 	"github.com/krateoplatformops/eventsse/internal/middlewares/access"
 	"github.com/krateoplatformops/eventsse/internal/store"
 	"github.com/krateoplatformops/plumbing/server/use"
 	"github.com/krateoplatformops/plumbing/server/use/cors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 
 	_ "github.com/krateoplatformops/eventsse/docs"
@@ -41,6 +46,26 @@ func main() {
 	limit := flag.Int("limit", env.Int("EVENTSSE_GET_LIMIT", defaultLimit),
 		"limits the number of results to return from 'Get' request")
 	endpoints := flag.String("etcd-servers", env.String("EVENTSSE_ETCD_SERVERS", "localhost:2379"), "etcd endpoints")
+	compositionIdLabel := flag.String("composition-id-label",
+		env.String("EVENTSSE_COMPOSITION_ID_LABEL", labels.DefaultCompositionIDKey),
+		"event label holding the composition id (must match the eventrouter one)")
+	bufferSize := flag.Int("sse-buffer-size", env.Int("EVENTSSE_SSE_BUFFER_SIZE", hub.DefaultBufferSize),
+		"number of events buffered for each SSE client")
+	slowConsumerPolicy := flag.String("sse-slow-consumer-policy",
+		env.String("EVENTSSE_SSE_SLOW_CONSUMER_POLICY", string(hub.PolicyDisconnect)),
+		"what happens when a SSE client buffer is full: drop (the event) or disconnect (the client)")
+	heartbeat := flag.Duration("sse-heartbeat", env.Duration("EVENTSSE_SSE_HEARTBEAT", 15*time.Second),
+		"how often a heartbeat comment is sent on idle SSE streams, and a ping on WebSocket connections (0 to disable)")
+	retry := flag.Duration("sse-retry", env.Duration("EVENTSSE_SSE_RETRY", 3*time.Second),
+		"reconnection delay suggested to the SSE clients (0 to disable)")
+	writeTimeout := flag.Duration("sse-write-timeout", env.Duration("EVENTSSE_SSE_WRITE_TIMEOUT", 10*time.Second),
+		"deadline of each SSE and WebSocket message write")
+	maxLifetime := flag.Duration("sse-max-lifetime", env.Duration("EVENTSSE_SSE_MAX_LIFETIME", 30*time.Minute),
+		"how long a SSE stream or WebSocket connection lasts, give or take 10%, before being closed, the client then reconnects (0 for no limit)")
+	wsMaxSubscriptions := flag.Int("ws-max-subscriptions", env.Int("EVENTSSE_WS_MAX_SUBSCRIPTIONS", 20),
+		"how many compositions a WebSocket connection can subscribe at once (0 for no limit)")
+	corsAllowedOrigins := flag.String("cors-allowed-origins", env.String("EVENTSSE_CORS_ALLOWED_ORIGINS", "*"),
+		"comma separated origins allowed by the CORS policy and to open WebSocket connections")
 
 	flag.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), "Flags:")
@@ -53,6 +78,14 @@ func main() {
 		*limit = defaultLimit
 	}
 
+	labels.SetCompositionIDKey(*compositionIdLabel)
+
+	policy, err := hub.ParsePolicy(*slowConsumerPolicy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
 	// Initialize the logger
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
@@ -73,7 +106,16 @@ func main() {
 			Str("port", fmt.Sprintf("%d", *port)).
 			Str("ttl", fmt.Sprintf("%d", *ttlSecs)).
 			Str("limit", fmt.Sprintf("%d", *limit)).
-			Str("etcd-endpoints", *endpoints)
+			Str("etcd-endpoints", *endpoints).
+			Str("composition-id-label", *compositionIdLabel).
+			Str("sse-buffer-size", fmt.Sprintf("%d", *bufferSize)).
+			Str("sse-slow-consumer-policy", string(policy)).
+			Str("sse-heartbeat", heartbeat.String()).
+			Str("sse-retry", retry.String()).
+			Str("sse-write-timeout", writeTimeout.String()).
+			Str("sse-max-lifetime", maxLifetime.String()).
+			Str("ws-max-subscriptions", fmt.Sprintf("%d", *wsMaxSubscriptions)).
+			Str("cors-allowed-origins", *corsAllowedOrigins)
 
 		if *dumpEnv {
 			evt = evt.Strs("env-vars", os.Environ())
@@ -82,6 +124,11 @@ func main() {
 		evt.Msg("configuration and env vars")
 	}
 
+	allowedOrigins := strings.Split(*corsAllowedOrigins, ",")
+	for i := range allowedOrigins {
+		allowedOrigins[i] = strings.TrimSpace(allowedOrigins[i])
+	}
+
 	opts := store.Options{
 		Endpoints: strings.Split(*endpoints, ","),
 	}
@@ -101,6 +148,39 @@ func main() {
 		log.Fatal().Err(err).Msg("could not create ETCD watcher")
 	}
 
+	notifications := hub.New(hub.Options{
+		Watcher:    watcher,
+		BufferSize: *bufferSize,
+		Policy:     policy,
+		Log:        log,
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "eventsse_hub_watches",
+		Help: "Number of etcd watches open by the hub.",
+	}, func() float64 { return float64(notifications.Watches()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "eventsse_hub_subscribers",
+		Help: "Number of SSE clients subscribed to the hub.",
+	}, func() float64 {
+		subs, _, _ := notifications.Stats()
+		return float64(subs)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "eventsse_hub_buffered_events",
+		Help: "Number of events waiting in the SSE clients buffers.",
+	}, func() float64 {
+		_, buffered, _ := notifications.Stats()
+		return float64(buffered)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "eventsse_hub_max_subscriber_lag",
+		Help: "Number of events waiting in the fullest SSE client buffer.",
+	}, func() float64 {
+		_, _, lag := notifications.Stats()
+		return float64(lag)
+	})
+
 	healthy := int32(0)
 
 	mux := http.NewServeMux()
@@ -109,15 +189,35 @@ func main() {
 		Store: storage,
 		TTL:   time.Duration(*ttlSecs) * time.Second,
 	}))
-	mux.Handle("GET /notifications", pub.SSE(watcher))
+	closing := make(chan struct{})
+
+	mux.Handle("GET /notifications", pub.SSE(pub.SSEOptions{
+		Hub:          notifications,
+		Client:       watcher,
+		Heartbeat:    *heartbeat,
+		Retry:        *retry,
+		WriteTimeout: *writeTimeout,
+		MaxLifetime:  *maxLifetime,
+		Closing:      closing,
+	}))
+	mux.Handle("GET /ws/notifications", pub.WebSocket(pub.WebSocketOptions{
+		Hub:              notifications,
+		Heartbeat:        *heartbeat,
+		WriteTimeout:     *writeTimeout,
+		MaxLifetime:      *maxLifetime,
+		MaxSubscriptions: *wsMaxSubscriptions,
+		AllowedOrigins:   allowedOrigins,
+		Closing:          closing,
+	}))
 	mux.Handle("GET /events", getter.Events(storage, *limit))
 	mux.Handle("GET /events/{composition}", getter.Events(storage, *limit))
+	mux.Handle("GET /metrics", promhttp.Handler())
 	mux.Handle("/swagger/", httpSwagger.WrapHandler)
 
 	chain := use.NewChain(
 		access.Access(log),
 		use.CORS(cors.Options{
-			AllowedOrigins: []string{"*"},
+			AllowedOrigins: allowedOrigins,
 			AllowedMethods: []string{"GET", "POST", "OPTIONS"},
 			AllowedHeaders: []string{
 				"Accept",
@@ -139,6 +239,9 @@ func main() {
 		WriteTimeout: 50 * time.Second,
 		IdleTimeout:  30 * time.Second,
 	}
+	// Shutdown doesn't interrupt the active connections: the
+	// SSE streams are closed, the clients reconnect elsewhere
+	server.RegisterOnShutdown(func() { close(closing) })
 
 	ctx, stop := signal.NotifyContext(context.Background(), []os.Signal{
 		os.Interrupt,